@@ -23,7 +23,7 @@ if %errorlevel% neq 0 (
 
 REM Build Go binary
 echo Building Go server...
-go build -o bitplay.exe main.go
+go build -o bitplay.exe .
 if %errorlevel% neq 0 (
     echo Go build failed
     exit /b 1
@@ -24,7 +24,7 @@ fi
 
 # Build Go binary
 echo "🔧 Building Go server..."
-go build -o bitplay main.go
+go build -o bitplay .
 if [ $? -ne 0 ]; then
     echo "❌ Go build failed"
     exit 1
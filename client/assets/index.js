@@ -115,7 +115,16 @@ videojs.registerPlugin('doubleTapFF', doubleTapFF);
       return;
     }
 
-    const { sessionId } = await res.json();
+    const { sessionId, warning } = await res.json();
+    if (warning) {
+      butterup.toast({
+        message: warning,
+        location: "top-right",
+        icon: true,
+        dismissable: true,
+        type: "warning",
+      });
+    }
     const filesRes = await fetch("/api/v1/torrent/" + sessionId);
 
     if (!filesRes.ok) {
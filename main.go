@@ -3,32 +3,50 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"math/rand"
+	"mime"
 	"net"
 	"net/http"
 	"os"
+	"os/exec"
 	"reflect"
 	"runtime"
+	"runtime/debug"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode"
 
 	"net/url"
+	"path"
 	"path/filepath"
 
+	"github.com/anacrolix/dht/v2"
 	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
 	"github.com/anacrolix/torrent/metainfo"
 	"github.com/anacrolix/torrent/storage"
+	"github.com/anacrolix/torrent/tracker"
 	"golang.org/x/net/proxy"
 
 	"database/sql"
-	_ "modernc.org/sqlite"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
 )
 
 func init() {
@@ -48,6 +66,148 @@ type TorrentSession struct {
 	Port        int
 	LastUsed    time.Time
 	TempDataDir string // Track temp directory for cleanup
+	CreatedAt   time.Time
+	SeedGrace   time.Duration // How long the session keeps seeding once unused, zero when it doesn't upload
+
+	// Bandwidth history sampled periodically by trackBandwidth
+	bandwidthMu      sync.Mutex
+	bandwidthSamples []BandwidthSample
+
+	// Stall watchdog state, maintained by watchStalls
+	stallMu            sync.Mutex
+	activeStreams      int
+	lastBytesCompleted int64
+	lastProgress       time.Time
+	stalledSince       time.Time // Zero unless the session is stalled
+
+	rechecking atomic.Bool // Set while a forced data recheck is running
+
+	metadataLoading atomic.Bool // Set while an async add waits for the torrent info
+
+	lastReannounce atomic.Int64 // Unix nanoseconds of the last manual re-announce
+
+	durationsMu   sync.Mutex
+	fileDurations map[int]float64 // File index -> duration in seconds found by ffprobe
+	probingFiles  map[int]bool    // File indexes with a duration probe running
+}
+
+// Duration of a file found by an earlier probe
+func (s *TorrentSession) fileDuration(fileIndex int) (float64, bool) {
+	s.durationsMu.Lock()
+	defer s.durationsMu.Unlock()
+	duration, ok := s.fileDurations[fileIndex]
+	return duration, ok
+}
+
+func (s *TorrentSession) setFileDuration(fileIndex int, duration float64) {
+	s.durationsMu.Lock()
+	defer s.durationsMu.Unlock()
+	if s.fileDurations == nil {
+		s.fileDurations = make(map[int]float64)
+	}
+	s.fileDurations[fileIndex] = duration
+}
+
+// A point-in-time reading of how much data a session has downloaded
+type BandwidthSample struct {
+	Time      time.Time `json:"time"`
+	BytesRead int64     `json:"bytesRead"`
+}
+
+// Total payload bytes downloaded by this session so far
+func (s *TorrentSession) BytesRead() int64 {
+	stats := s.Torrent.Stats()
+	return stats.BytesReadData.Int64()
+}
+
+// Record the current byte count, keeping only the most recent samples
+func (s *TorrentSession) recordBandwidthSample() {
+	sample := BandwidthSample{Time: time.Now(), BytesRead: s.BytesRead()}
+
+	s.bandwidthMu.Lock()
+	defer s.bandwidthMu.Unlock()
+	s.bandwidthSamples = append(s.bandwidthSamples, sample)
+	if len(s.bandwidthSamples) > maxBandwidthSamples {
+		s.bandwidthSamples = s.bandwidthSamples[len(s.bandwidthSamples)-maxBandwidthSamples:]
+	}
+}
+
+// Average download rate in bytes/sec over roughly the last downloadRateWindow,
+// measured against the oldest bandwidth sample in that window. Sessions too
+// young to have a sample are measured from their creation.
+func (s *TorrentSession) DownloadRate() float64 {
+	now := time.Now()
+	baseline := BandwidthSample{Time: s.CreatedAt}
+
+	s.bandwidthMu.Lock()
+	for _, sample := range s.bandwidthSamples {
+		if now.Sub(sample.Time) <= downloadRateWindow {
+			baseline = sample
+			break
+		}
+	}
+	s.bandwidthMu.Unlock()
+
+	elapsed := now.Sub(baseline.Time).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(s.BytesRead()-baseline.BytesRead) / elapsed
+}
+
+// Track open streams so the watchdog only judges sessions someone is watching
+func (s *TorrentSession) streamStarted() {
+	s.stallMu.Lock()
+	defer s.stallMu.Unlock()
+	if s.activeStreams == 0 {
+		s.lastProgress = time.Now()
+	}
+	s.activeStreams++
+}
+
+func (s *TorrentSession) streamFinished() {
+	s.stallMu.Lock()
+	defer s.stallMu.Unlock()
+	s.activeStreams--
+	if s.activeStreams == 0 {
+		s.stalledSince = time.Time{}
+	}
+}
+
+// Compare progress against the last check and update the stalled state
+func (s *TorrentSession) checkStall(timeout time.Duration) {
+	bytesCompleted := s.Torrent.BytesCompleted()
+
+	s.stallMu.Lock()
+	defer s.stallMu.Unlock()
+	if bytesCompleted != s.lastBytesCompleted || s.activeStreams == 0 {
+		s.lastBytesCompleted = bytesCompleted
+		s.lastProgress = time.Now()
+		s.stalledSince = time.Time{}
+		return
+	}
+	// Nothing left to download is not a stall
+	if bytesCompleted >= s.Torrent.Length() {
+		return
+	}
+	if s.stalledSince.IsZero() && time.Since(s.lastProgress) > timeout {
+		s.stalledSince = time.Now()
+		log.Printf("Session %s stalled: no progress for %s", s.Torrent.InfoHash().HexString(), timeout)
+	}
+}
+
+// When the session stalled, or the zero time if it is making progress
+func (s *TorrentSession) StalledSince() time.Time {
+	s.stallMu.Lock()
+	defer s.stallMu.Unlock()
+	return s.stalledSince
+}
+
+// Copy of the recorded bandwidth history
+func (s *TorrentSession) BandwidthHistory() []BandwidthSample {
+	s.bandwidthMu.Lock()
+	defer s.bandwidthMu.Unlock()
+	return append([]BandwidthSample{}, s.bandwidthSamples...)
 }
 
 type Settings struct {
@@ -60,11 +220,66 @@ type Settings struct {
 	JackettHost    string `json:"jackettHost"`
 	JackettApiKey  string `json:"jackettApiKey"`
 	YTSServerURL   string `json:"ytsServerUrl"` // YTS API server URL
+
+	PrebufferSeconds    int `json:"prebufferSeconds"`    // Seconds of video to buffer before playback is "ready"
+	StallTimeoutSeconds int `json:"stallTimeoutSeconds"` // Seconds without progress before a stream is reported stalled
+
+	BlockedInfohashes []string `json:"blockedInfohashes"` // Torrents that may not be added
+	BlockedTrackers   []string `json:"blockedTrackers"`   // Trackers stripped from magnets (substring match)
+
+	TrackerListURL string `json:"trackerListUrl"` // Newline-separated tracker list used instead of the built-in one
+
+	TLSCertFile string `json:"tlsCertFile"` // Serve HTTPS when both cert and key are set
+	TLSKeyFile  string `json:"tlsKeyFile"`
+
+	StreamCORSOrigin string `json:"streamCorsOrigin"` // Access-Control-Allow-Origin for stream responses
+
+	AllowedStreamExtensions []string `json:"allowedStreamExtensions"` // Only files with these extensions are streamed; empty allows all
+
+	InstanceID string `json:"instanceId"` // Tags this instance's temp dirs so instances sharing a host don't delete each other's
+
+	DisableFavorites bool `json:"disableFavorites"` // Run stateless: no favorites.db, favorites endpoints answer 501
+
+	DisableSPAFallback bool `json:"disableSpaFallback"` // Answer unknown frontend routes with 404 instead of index.html
+
+	MaxMagnetLength int   `json:"maxMagnetLength"` // Longest magnet or download URL accepted, in bytes
+	MaxUploadSize   int64 `json:"maxUploadSize"`   // Largest uploaded .torrent file accepted, in bytes
+
+	PortRangeStart int `json:"portRangeStart"` // Session listen ports are picked from [start, end)
+	PortRangeEnd   int `json:"portRangeEnd"`
+
+	AvoidEphemeralPorts bool `json:"avoidEphemeralPorts"` // Keep listen ports below the OS ephemeral range when it is known
+
+	DefaultSortBy  string `json:"defaultSortBy"` // YTS browse order used when the client doesn't pick one
+	DefaultOrderBy string `json:"defaultOrderBy"`
+
+	ReadHeaderTimeoutSeconds int `json:"readHeaderTimeoutSeconds"` // Time a client gets to send request headers
+	IdleTimeoutSeconds       int `json:"idleTimeoutSeconds"`       // Time an idle keep-alive connection is kept open
+	APITimeoutSeconds        int `json:"apiTimeoutSeconds"`        // Read/write deadline for API requests; streams have none
+
+	PrivacyMode bool `json:"privacyMode"` // Hide peer IP addresses in diagnostics
+
+	MinReadaheadMB int `json:"minReadaheadMB"` // Bounds of the stream readahead, which follows the download rate
+	MaxReadaheadMB int `json:"maxReadaheadMB"`
+
+	DHTBootstrapNodes []string `json:"dhtBootstrapNodes"` // host:port DHT routers used instead of the library defaults
+
+	BtsowMaxResults int `json:"btsowMaxResults"` // Most magnets taken from one btsow search
+
+	IdleConnCleanupSeconds int `json:"idleConnCleanupSeconds"` // How often unused outbound HTTP clients drop idle connections
+
+	StripUDPTrackersWithProxy bool `json:"stripUdpTrackersWithProxy"` // Drop UDP trackers from magnets while the SOCKS5 proxy is on
+
+	SeedAfterStream     bool `json:"seedAfterStream"`     // Upload to the swarm, keeping unused sessions up for SeedDurationMinutes
+	SeedDurationMinutes int  `json:"seedDurationMinutes"` // Seeding grace period once a session is no longer used
+
+	FallbackProxyURLs []string `json:"fallbackProxyUrls"` // SOCKS5 proxies tried in order when ProxyURL can't be reached
 }
 
 type ProxySettings struct {
-	EnableProxy bool   `json:"enableProxy"`
-	ProxyURL    string `json:"proxyUrl"`
+	EnableProxy       bool     `json:"enableProxy"`
+	ProxyURL          string   `json:"proxyUrl"`
+	FallbackProxyURLs []string `json:"fallbackProxyUrls"` // Left as is when omitted
 }
 
 type ProwlarrSettings struct {
@@ -83,12 +298,234 @@ type YTSSettings struct {
 	YTSServerURL string `json:"ytsServerUrl"`
 }
 
+type BlocklistSettings struct {
+	BlockedInfohashes []string `json:"blockedInfohashes"`
+	BlockedTrackers   []string `json:"blockedTrackers"`
+}
+
+type StreamExtensionSettings struct {
+	AllowedStreamExtensions []string `json:"allowedStreamExtensions"`
+}
+
+type PortRangeSettings struct {
+	PortRangeStart      int  `json:"portRangeStart"`
+	PortRangeEnd        int  `json:"portRangeEnd"`
+	AvoidEphemeralPorts bool `json:"avoidEphemeralPorts"`
+}
+
+type YTSSortSettings struct {
+	DefaultSortBy  string `json:"defaultSortBy"`
+	DefaultOrderBy string `json:"defaultOrderBy"`
+}
+
+const (
+	// Default number of seconds that must be buffered before playback starts
+	defaultPrebufferSeconds = 10
+	// Default number of seconds without download progress before a stream is stalled
+	defaultStallTimeoutSeconds = 60
+	// Assumed bitrate (bytes/sec) when the player doesn't know the duration yet (~5 Mbit/s)
+	defaultStreamBitrate = 625000
+
+	// Largest .torrent file accepted from an indexer download link
+	maxTorrentFileSize = 10 << 20 // 10MB
+
+	// Default largest .torrent file accepted as an upload
+	defaultMaxUploadSize = 10 << 20 // 10MB
+
+	// Largest favorites backup accepted for import
+	maxFavoritesImportSize = 32 << 20 // 32MB
+	// Most movie IDs one favorites status request may ask about
+	maxFavoritesStatusIDs = 500
+
+	// Room in an add-torrent request body beyond the magnet itself, for
+	// the file list, name and JSON framing
+	addTorrentBodySlack = 64 << 10 // 64KB
+
+	// YTS page size used when the client doesn't ask for one, and the most YTS allows
+	defaultYTSLimit = 20
+	maxYTSLimit     = 50
+
+	// Results asked of Prowlarr, so minSeeders still has enough to choose
+	// from, and how many of the best seeded are returned
+	prowlarrFetchLimit = 100
+	prowlarrMaxResults = 10
+
+	// How often session bandwidth is sampled, and how many samples are kept (1 hour)
+	bandwidthSampleInterval = 30 * time.Second
+	maxBandwidthSamples     = 120
+
+	// Span of bandwidth samples the download rate for ETAs is averaged over
+	downloadRateWindow = 5 * bandwidthSampleInterval
+
+	// Default range session listen ports are picked from, and the bounds
+	// a configured range must stay within
+	defaultPortRangeStart = 10000
+	defaultPortRangeEnd   = 60000
+	minListenPort         = 1024
+	maxListenPort         = 65535
+
+	// Default bounds of the adaptive stream readahead, in MB
+	defaultMinReadaheadMB = 8
+	defaultMaxReadaheadMB = 64
+
+	// Default cap on btsow search results, and how long a search may take
+	defaultBtsowMaxResults = 10
+	btsowTimeout           = 10 * time.Second
+
+	// Ports tried for a new session's client before giving up
+	clientBindAttempts = 3
+
+	// Default interval for closing idle outbound HTTP connections, in seconds
+	defaultIdleConnCleanupSeconds = 60
+
+	// Default seeding grace period when SeedAfterStream is on, in minutes
+	defaultSeedDurationMinutes = 30
+
+	// Default server timeouts, in seconds
+	defaultReadHeaderTimeoutSeconds = 10
+	defaultIdleTimeoutSeconds       = 120
+	defaultAPITimeoutSeconds        = 60
+
+	// YTS browse order used when neither the client nor the settings pick one
+	defaultYTSSortBy  = "date_added"
+	defaultYTSOrderBy = "desc"
+
+	// How often the remote tracker list is fetched, and how long a fetched
+	// list is still used while refreshes fail
+	trackerListRefreshInterval = 6 * time.Hour
+	trackerListTTL             = 24 * time.Hour
+	// Largest tracker list body read
+	maxTrackerListSize = 1 << 20 // 1MB
+
+	// Default longest magnet or download URL accepted from clients
+	defaultMaxMagnetLength = 8 << 10 // 8KB
+
+	// Trackers appended to a converted magnet unless the client asks otherwise
+	defaultMaxMagnetTrackers = 10
+
+	// Most magnets parsed by one infohash request
+	maxInfohashBatch = 500
+
+	// Trackers scraped for a health check, and how long they get to answer
+	maxHealthTrackers   = 20
+	healthScrapeTimeout = 15 * time.Second
+	// Seeders at which a torrent gets the full seeder part of its health score
+	healthFullSeeders = 50
+
+	// How long a disk usage scan is reused before walking the temp dirs again
+	diskUsageCacheTTL = 10 * time.Second
+
+	// Pause between YTS lookups when checking favorites, to stay under rate limits
+	favoritesPruneInterval = 500 * time.Millisecond
+
+	// Video bitrate used for ?maxbitrate-less transcodes, and the accepted range
+	transcodeDefaultBitrateKbps = 2500
+	minTranscodeBitrateKbps     = 100
+	maxTranscodeBitrateKbps     = 50000
+
+	// How long scraped Avmoo list and detail pages are reused
+	avmooCacheTTL = 10 * time.Minute
+
+	// How long YTS browse pages are reused
+	ytsCacheTTL = 5 * time.Minute
+
+	// Longest clip the clip endpoint cuts, how long generated clips are
+	// kept, and how many are kept at most
+	maxClipSeconds = 5 * 60
+	clipCacheTTL   = 30 * time.Minute
+	maxCachedClips = 20
+
+	// Longest ffprobe may take to read a file's track list
+	mediaProbeTimeout = time.Minute
+	// Longest the background ffprobe for a streamed file's duration may run
+	bitrateProbeTimeout = 15 * time.Second
+
+	// Minimum time between manual re-announces of a session, and how long
+	// a manual DHT lookup runs
+	reannounceCooldown   = 30 * time.Second
+	reannounceDHTTimeout = time.Minute
+
+	// Bytes http.DetectContentType looks at
+	sniffLength = 512
+
+	// Longest a stream waits for its ?prebuffer prefix before starting anyway
+	prebufferTimeout = 30 * time.Second
+
+	// Seconds of download the stream readahead aims to cover, and how often
+	// it is recomputed from the session's download rate
+	readaheadSeconds        = 20
+	readaheadUpdateInterval = 5 * time.Second
+
+	// How long to wait for torrent info after adding a magnet
+	metadataTimeout = 3 * time.Minute
+
+	// Sessions unused this long are closed, checked every sessionCleanupInterval
+	sessionIdleTimeout     = 10 * time.Minute
+	sessionCleanupInterval = 2 * time.Minute
+
+	// A port with no session is only reclaimed once it has been allocated this
+	// long, so adds still waiting for metadata keep theirs
+	portLeakGracePeriod = metadataTimeout + time.Minute
+
+	// How long SQLite waits on a locked database, and how often a favorites
+	// write is retried if it still comes back busy
+	sqliteBusyTimeoutMs   = 5000
+	favoritesWriteRetries = 3
+	favoritesRetryDelay   = 100 * time.Millisecond
+)
+
 var (
-	sessions  sync.Map
+	sessions  SessionStore
 	usedPorts sync.Map
 	portMutex sync.Mutex
 )
 
+// Open torrent sessions keyed by session ID (the infohash in hex). Wraps
+// a sync.Map so callers get typed values instead of asserting them.
+type SessionStore struct {
+	m sync.Map
+}
+
+// The session with this ID, if it is open
+func (s *SessionStore) Get(id string) (*TorrentSession, bool) {
+	value, ok := s.m.Load(id)
+	if !ok {
+		return nil, false
+	}
+	return value.(*TorrentSession), true
+}
+
+func (s *SessionStore) Store(id string, session *TorrentSession) {
+	s.m.Store(id, session)
+}
+
+// Store session unless one with this ID exists. Returns the session now
+// stored and whether it was already there.
+func (s *SessionStore) LoadOrStore(id string, session *TorrentSession) (*TorrentSession, bool) {
+	value, loaded := s.m.LoadOrStore(id, session)
+	return value.(*TorrentSession), loaded
+}
+
+// Remove a session, reporting whether it was there. Only one of several
+// concurrent callers sees true.
+func (s *SessionStore) Delete(id string) bool {
+	_, loaded := s.m.LoadAndDelete(id)
+	return loaded
+}
+
+// Remove the session stored under id only if it is still session, so
+// tearing down a stale session can't drop the one that replaced it
+func (s *SessionStore) CompareAndDelete(id string, session *TorrentSession) bool {
+	return s.m.CompareAndDelete(id, session)
+}
+
+// Call f for each session until it returns false
+func (s *SessionStore) Range(f func(id string, session *TorrentSession) bool) {
+	s.m.Range(func(key, value interface{}) bool {
+		return f(key.(string), value.(*TorrentSession))
+	})
+}
+
 // Helper function to format file sizes
 func formatSize(sizeInBytes float64) string {
 	if sizeInBytes < 1024 {
@@ -109,17 +546,62 @@ func formatSize(sizeInBytes float64) string {
 	return fmt.Sprintf("%.2f GB", sizeInGB)
 }
 
+// An HTTP client kept for one proxy configuration, and when it was last handed out
+type cachedHTTPClient struct {
+	client   *http.Client
+	lastUsed time.Time
+}
+
 var (
-	proxyTransport = &http.Transport{
-		// copy your existing timeouts & DialContext logic here...
+	selectiveClientsMu sync.Mutex
+	selectiveClients   = map[string]*cachedHTTPClient{} // Keyed by proxy chain, empty when direct
+)
+
+// Client for outbound traffic that follows the proxy settings. One client
+// is kept per proxy configuration, so requests reuse keep-alive connections
+// instead of sharing a transport that is rewired on every call.
+func createSelectiveProxyClient() *http.Client {
+	settingsMutex.RLock()
+	chain := proxyChain()
+	settingsMutex.RUnlock()
+	key := strings.Join(chain, " ")
+
+	selectiveClientsMu.Lock()
+	defer selectiveClientsMu.Unlock()
+
+	if cached, ok := selectiveClients[key]; ok {
+		cached.lastUsed = time.Now()
+		return cached.client
+	}
+
+	client := newSelectiveProxyClient(chain)
+	selectiveClients[key] = &cachedHTTPClient{client: client, lastUsed: time.Now()}
+	return client
+}
+
+func newSelectiveProxyClient(chain []string) *http.Client {
+	if len(chain) == 0 {
+		return &http.Client{Timeout: 30 * time.Second}
+	}
+
+	transport := &http.Transport{
 		TLSHandshakeTimeout:   10 * time.Second,
 		ResponseHeaderTimeout: 20 * time.Second,
 		ExpectContinueTimeout: 1 * time.Second,
 		IdleConnTimeout:       30 * time.Second,
 		MaxIdleConnsPerHost:   10,
 	}
-	proxyClient = &http.Client{
-		Transport: proxyTransport,
+	// Fail requests rather than send them around a broken proxy
+	dialer, err := createProxyDialer(chain...)
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if err != nil {
+			return nil, err
+		}
+		return dialer.Dial(network, addr)
+	}
+
+	return &http.Client{
+		Transport: transport,
 		Timeout:   30 * time.Second,
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			if len(via) >= 10 {
@@ -133,28 +615,188 @@ var (
 			return nil
 		},
 	}
+}
+
+// Periodically close the idle connections of HTTP clients that haven't been
+// used for a full interval, so quiet periods don't hold file descriptors.
+// Clients for a proxy configuration that is no longer current are dropped.
+func closeIdleHTTPConnections() {
+	settingsMutex.RLock()
+	interval := time.Duration(currentSettings.IdleConnCleanupSeconds) * time.Second
+	settingsMutex.RUnlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		closeUnusedHTTPClients(interval)
+	}
+}
+
+// One cleanup pass: clients not handed out within the last interval drop
+// their idle connections
+func closeUnusedHTTPClients(interval time.Duration) {
+	settingsMutex.RLock()
+	currentKey := strings.Join(proxyChain(), " ")
+	settingsMutex.RUnlock()
+
+	selectiveClientsMu.Lock()
+	for key, cached := range selectiveClients {
+		if time.Since(cached.lastUsed) < interval {
+			continue
+		}
+		cached.client.CloseIdleConnections()
+		if key != currentKey {
+			delete(selectiveClients, key)
+		}
+	}
+	selectiveClientsMu.Unlock()
+
+	indexerClientMu.Lock()
+	if indexerClient != nil && time.Since(indexerClientUsed) >= interval {
+		indexerClient.CloseIdleConnections()
+	}
+	indexerClientMu.Unlock()
+}
+
+var (
+	indexerClientMu   sync.Mutex
+	indexerClient     *http.Client
+	indexerClientKey  string // Proxy chain the indexer client was built for, empty when direct
+	indexerClientUsed time.Time
 )
 
-func createSelectiveProxyClient() *http.Client {
+// Long-lived client for Prowlarr/Jackett traffic. Unlike createSelectiveProxyClient
+// it is only rebuilt when the proxy configuration changes, so repeated searches
+// reuse keep-alive connections to the indexer.
+func getIndexerClient() *http.Client {
 	settingsMutex.RLock()
-	defer settingsMutex.RUnlock()
+	chain := proxyChain()
+	settingsMutex.RUnlock()
+	key := strings.Join(chain, " ")
+
+	indexerClientMu.Lock()
+	defer indexerClientMu.Unlock()
+	indexerClientUsed = time.Now()
+
+	if indexerClient != nil && indexerClientKey == key {
+		return indexerClient
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          20,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 20 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	if len(chain) > 0 {
+		// Fail requests rather than send them around a broken proxy
+		proxyDialer, err := createProxyDialer(chain...)
+		if err != nil {
+			log.Printf("Warning: Could not create proxy dialer for indexers: %v", err)
+		}
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if err != nil {
+				return nil, err
+			}
+			return proxyDialer.Dial(network, addr)
+		}
+	}
+
+	// Release the previous configuration's connections
+	if indexerClient != nil {
+		indexerClient.CloseIdleConnections()
+	}
+
+	indexerClient = &http.Client{
+		Transport: transport,
+		Timeout:   30 * time.Second,
+	}
+	indexerClientKey = key
+	return indexerClient
+}
 
+// Proxies to dial through, in failover order: ProxyURL, then the
+// fallbacks. Empty when the proxy is off. Callers hold settingsMutex.
+func proxyChain() []string {
 	if !currentSettings.EnableProxy {
-		return &http.Client{Timeout: 30 * time.Second}
+		return nil
+	}
+	chain := []string{currentSettings.ProxyURL}
+	for _, fallback := range currentSettings.FallbackProxyURLs {
+		if fallback = strings.TrimSpace(fallback); fallback != "" && !slices.Contains(chain, fallback) {
+			chain = append(chain, fallback)
+		}
+	}
+	return chain
+}
+
+// Create a proxy dialer for SOCKS5. With several proxies the dialer fails
+// over to the next one when a proxy can't be reached. Only the first proxy
+// is required to be valid; broken fallbacks are skipped.
+func createProxyDialer(proxyURLs ...string) (proxy.Dialer, error) {
+	if len(proxyURLs) == 0 {
+		return nil, errors.New("no proxy URL")
+	}
+
+	primary, err := createSOCKS5Dialer(proxyURLs[0])
+	if err != nil {
+		return nil, err
+	}
+	dialers := []proxy.Dialer{primary}
+	names := []string{proxyURLs[0]}
+	for _, fallback := range proxyURLs[1:] {
+		dialer, err := createSOCKS5Dialer(fallback)
+		if err == nil {
+			err = validateSOCKS5URL(fallback)
+		}
+		if err != nil {
+			log.Printf("Skipping fallback proxy %s: %v", redactProxyURL(fallback), err)
+			continue
+		}
+		dialers = append(dialers, dialer)
+		names = append(names, fallback)
+	}
+	if len(dialers) == 1 {
+		return primary, nil
 	}
-	// Reconfigure proxyTransport’s DialContext if URL changed:
-	dialer, _ := createProxyDialer(currentSettings.ProxyURL)
-	proxyTransport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return &failoverDialer{dialers: dialers, names: names}, nil
+}
+
+// DialContext for a proxy dialer, honouring the context when the dialer can
+func proxyDialContext(dialer proxy.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if contextDialer, ok := dialer.(proxy.ContextDialer); ok {
+		return contextDialer.DialContext
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
 		return dialer.Dial(network, addr)
 	}
-	// Drop any old idle conns after reconfiguration:
-	proxyTransport.CloseIdleConnections()
+}
 
-	return proxyClient
+// Check a proxy URL names a SOCKS5 proxy
+func validateSOCKS5URL(proxyURL string) error {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "socks5" && u.Scheme != "socks5h" {
+		return fmt.Errorf("unsupported scheme %q, only socks5 is", u.Scheme)
+	}
+	if u.Hostname() == "" || u.Port() == "" {
+		return errors.New("host and port are required")
+	}
+	return nil
 }
 
-// Create a proxy dialer for SOCKS5
-func createProxyDialer(proxyURL string) (proxy.Dialer, error) {
+func createSOCKS5Dialer(proxyURL string) (proxy.Dialer, error) {
 	proxyURLParsed, err := url.Parse(proxyURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse proxy URL: %v", err)
@@ -173,27 +815,158 @@ func createProxyDialer(proxyURL string) (proxy.Dialer, error) {
 	return proxy.SOCKS5("tcp", proxyURLParsed.Host, auth, proxy.Direct)
 }
 
+// Dials through the first proxy that works, starting from the one that
+// last succeeded so a dead primary isn't retried on every connection
+type failoverDialer struct {
+	dialers []proxy.Dialer
+	names   []string     // Proxy URLs, for logs
+	current atomic.Int32 // Index of the proxy that last worked
+}
+
+func (d *failoverDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+func (d *failoverDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	start := int(d.current.Load())
+	var errs []error
+	for i := range d.dialers {
+		index := (start + i) % len(d.dialers)
+		conn, err := proxyDialContext(d.dialers[index])(ctx, network, addr)
+		if err == nil {
+			if index != start && d.current.CompareAndSwap(int32(start), int32(index)) {
+				log.Printf("Proxy failover: now using %s", redactProxyURL(d.names[index]))
+			}
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", redactProxyURL(d.names[index]), err))
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Check a listen port range is usable: start below end, both unprivileged
+func validatePortRange(start, end int) error {
+	if start < minListenPort || end > maxListenPort {
+		return fmt.Errorf("port range must be within %d-%d", minListenPort, maxListenPort)
+	}
+	if start >= end {
+		return errors.New("port range start must be below its end")
+	}
+	return nil
+}
+
+// Values YTS accepts for sort_by and order_by
+var (
+	ytsSortFields = []string{"title", "year", "rating", "peers", "seeds", "download_count", "like_count", "date_added"}
+	ytsSortOrders = []string{"desc", "asc"}
+)
+
+// Genres YTS can filter list_movies by
+var ytsGenres = []string{
+	"Action", "Adventure", "Animation", "Biography", "Comedy", "Crime",
+	"Documentary", "Drama", "Family", "Fantasy", "Film-Noir", "Game-Show",
+	"History", "Horror", "Music", "Musical", "Mystery", "News", "Reality-TV",
+	"Romance", "Sci-Fi", "Sport", "Talk-Show", "Thriller", "War", "Western",
+}
+
+// The YTS genre matching name case-insensitively, if there is one
+func lookupYTSGenre(name string) (string, bool) {
+	for _, genre := range ytsGenres {
+		if strings.EqualFold(genre, name) {
+			return genre, true
+		}
+	}
+	return "", false
+}
+
+// Check a sort field and order are ones YTS understands
+func validateYTSSort(sortBy, orderBy string) error {
+	if !slices.Contains(ytsSortFields, sortBy) {
+		return fmt.Errorf("sort_by must be one of %s", strings.Join(ytsSortFields, ", "))
+	}
+	if !slices.Contains(ytsSortOrders, orderBy) {
+		return fmt.Errorf("order_by must be one of %s", strings.Join(ytsSortOrders, ", "))
+	}
+	return nil
+}
+
 // Implement a port allocation function to prevent conflicts
 func getAvailablePort() int {
+	rangeStart, rangeEnd := listenPortRange()
+
 	portMutex.Lock()
 	defer portMutex.Unlock()
 
 	// Try up to 50 times to find an unused port
 	for i := 0; i < 50; i++ {
-		// Generate a random port in the high range
-		port := 10000 + rand.Intn(50000)
+		// Generate a random port in the configured range
+		port := rangeStart + rand.Intn(rangeEnd-rangeStart)
 
 		// Check if this port is already in use by our app
 		if _, exists := usedPorts.Load(port); !exists {
-			// Mark this port as used
-			usedPorts.Store(port, true)
+			// Mark this port as used, remembering when for leak detection
+			usedPorts.Store(port, time.Now())
 			return port
 		}
 	}
 
-	// If we can't find an available port, return a very high random port
-	// as a last resort
-	return 60000 + rand.Intn(5000)
+	// If we can't find an available port, return a random one from the
+	// range as a last resort, so firewall rules for it still apply
+	return rangeStart + rand.Intn(rangeEnd-rangeStart)
+}
+
+// Range listen ports are picked from: the configured one, cut off below
+// the OS ephemeral range when that is asked for and leaves room
+func listenPortRange() (start, end int) {
+	settingsMutex.RLock()
+	start = currentSettings.PortRangeStart
+	end = currentSettings.PortRangeEnd
+	avoidEphemeral := currentSettings.AvoidEphemeralPorts
+	settingsMutex.RUnlock()
+
+	if !avoidEphemeral {
+		return start, end
+	}
+	if ephemeralStart, ok := ephemeralPortStart(); ok {
+		return clampBelowEphemeral(start, end, ephemeralStart)
+	}
+	return start, end
+}
+
+// Cut [start, end) off at ephemeralStart, keeping the range as is if
+// nothing would be left of it
+func clampBelowEphemeral(start, end, ephemeralStart int) (int, int) {
+	if ephemeralStart <= start || ephemeralStart >= end {
+		return start, end
+	}
+	return start, ephemeralStart
+}
+
+var (
+	ephemeralPortsOnce  sync.Once
+	ephemeralPortsStart int
+)
+
+// First port of the OS ephemeral range, read once from procfs. Only Linux
+// exposes it there; elsewhere ok is false.
+func ephemeralPortStart() (int, bool) {
+	ephemeralPortsOnce.Do(func() {
+		data, err := os.ReadFile("/proc/sys/net/ipv4/ip_local_port_range")
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(string(data))
+		if len(fields) != 2 {
+			return
+		}
+		if start, err := strconv.Atoi(fields[0]); err == nil && start > 0 {
+			ephemeralPortsStart = start
+		}
+	})
+	return ephemeralPortsStart, ephemeralPortsStart > 0
 }
 
 // Release a port when we're done with it
@@ -203,21 +976,75 @@ func releasePort(port int) {
 	usedPorts.Delete(port)
 }
 
-// Initialize the torrent client with proxy settings
+// Initialize the torrent client with proxy settings, retrying on another
+// port when the listen port can't be bound.
 // Returns: client, port, tempDir, error
 func initTorrentWithProxy() (*torrent.Client, int, string, error) {
 	settingsMutex.RLock()
 	enableProxy := currentSettings.EnableProxy
 	proxyURL := currentSettings.ProxyURL
+	chain := proxyChain()
+	bootstrapNodes := slices.Clone(currentSettings.DHTBootstrapNodes)
 	settingsMutex.RUnlock()
 
-	config := torrent.NewDefaultClientConfig()
+	var proxyDialer proxy.Dialer
+	if enableProxy {
+		os.Setenv("ALL_PROXY", proxyURL)
+		os.Setenv("SOCKS_PROXY", proxyURL)
+		os.Setenv("HTTP_PROXY", proxyURL)
+		os.Setenv("HTTPS_PROXY", proxyURL)
+
+		var err error
+		proxyDialer, err = createProxyDialer(chain...)
+		if err != nil {
+			return nil, 0, "", fmt.Errorf("could not create proxy dialer: %v", err)
+		}
+	} else {
+		os.Unsetenv("ALL_PROXY")
+		os.Unsetenv("SOCKS_PROXY")
+		os.Unsetenv("HTTP_PROXY")
+		os.Unsetenv("HTTPS_PROXY")
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= clientBindAttempts; attempt++ {
+		client, port, tempDir, err := newSessionClient(proxyDialer, bootstrapNodes)
+		if err == nil {
+			return client, port, tempDir, nil
+		}
+		// Only a port that's already taken is worth another try
+		if !isBindError(err) {
+			return nil, 0, "", err
+		}
+		log.Printf("Torrent client failed on port %d (attempt %d/%d): %v", port, attempt, clientBindAttempts, err)
+		lastErr = err
+	}
+	return nil, 0, "", fmt.Errorf("%w: %v", errNoListenPort, lastErr)
+}
+
+// Whether err comes from a listen port that is already in use
+func isBindError(err error) bool {
+	return errors.Is(err, syscall.EADDRINUSE)
+}
+
+var (
+	errNoListenPort = errors.New("no available listen port")
+	errTempDir      = errors.New("failed to create temp directory")
+)
+
+// Create a client listening on a freshly allocated port. On failure the
+// port and temp directory are released again.
+func newSessionClient(proxyDialer proxy.Dialer, bootstrapNodes []string) (*torrent.Client, int, string, error) {
+	config := torrent.NewDefaultClientConfig()
+	if len(bootstrapNodes) > 0 {
+		config.DhtStartingNodes = dhtStartingNodes(bootstrapNodes)
+	}
 
 	// Create unique temp directory for this session in OS temp location
 	// This will be automatically cleaned up by OS or our cleanup routine
-	tempDir, err := os.MkdirTemp("", "bitplay-torrent-*")
+	tempDir, err := os.MkdirTemp("", tempDirPrefix()+"*")
 	if err != nil {
-		return nil, 0, "", fmt.Errorf("failed to create temp directory: %w", err)
+		return nil, 0, "", fmt.Errorf("%w: %w", errTempDir, err)
 	}
 
 	// Use temp directory for storage - will be deleted when session ends
@@ -225,61 +1052,54 @@ func initTorrentWithProxy() (*torrent.Client, int, string, error) {
 	port := getAvailablePort()
 	config.ListenPort = port
 
-	// Disable uploading/seeding
-	config.NoUpload = true
-	config.Seed = false
+	configureSeeding(config)
 	config.DisableTrackers = false // Keep trackers for getting peers
 	config.DisablePEX = true        // Disable peer exchange
 	config.DisableIPv6 = false
 
-	// Set upload rate to 0 to prevent any uploading
-	config.UploadRateLimiter = nil
-
-	if enableProxy {
-		os.Setenv("ALL_PROXY", proxyURL)
-		os.Setenv("SOCKS_PROXY", proxyURL)
-		os.Setenv("HTTP_PROXY", proxyURL)
-		os.Setenv("HTTPS_PROXY", proxyURL)
-
-		proxyDialer, err := createProxyDialer(proxyURL)
-		if err != nil {
-			releasePort(port)
-			os.RemoveAll(tempDir)
-			return nil, port, "", fmt.Errorf("could not create proxy dialer: %v", err)
-		}
-
-		config.HTTPProxy = func(*http.Request) (*url.URL, error) {
-			return url.Parse(proxyURL)
-		}
-
-		client, err := torrent.NewClient(config)
-		if err != nil {
-			releasePort(port)
-			os.RemoveAll(tempDir) // Clean up temp dir on error
-			return nil, port, "", err
-		}
-
-		setValue(client, "dialerNetwork", func(ctx context.Context, network, addr string) (net.Conn, error) {
-			return proxyDialer.Dial(network, addr)
-		})
-
-		return client, port, tempDir, nil
+	// HTTP trackers and web seeds dial through the same failover chain as peers
+	if proxyDialer != nil {
+		config.HTTPDialContext = proxyDialContext(proxyDialer)
+		config.TrackerDialContext = proxyDialContext(proxyDialer)
 	}
 
-	os.Unsetenv("ALL_PROXY")
-	os.Unsetenv("SOCKS_PROXY")
-	os.Unsetenv("HTTP_PROXY")
-	os.Unsetenv("HTTPS_PROXY")
-
 	client, err := torrent.NewClient(config)
 	if err != nil {
 		releasePort(port)
 		os.RemoveAll(tempDir) // Clean up temp dir on error
 		return nil, port, "", err
 	}
+
+	if proxyDialer != nil {
+		setValue(client, "dialerNetwork", func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return proxyDialer.Dial(network, addr)
+		})
+	}
 	return client, port, tempDir, nil
 }
 
+// Resolve the configured DHT bootstrap nodes when the DHT starts. Nodes
+// that don't resolve are skipped, so one dead entry doesn't stop the rest.
+func dhtStartingNodes(nodes []string) func(network string) dht.StartingNodesGetter {
+	return func(network string) dht.StartingNodesGetter {
+		return func() ([]dht.Addr, error) {
+			var addrs []dht.Addr
+			for _, node := range nodes {
+				udpAddr, err := net.ResolveUDPAddr(network, node)
+				if err != nil {
+					log.Printf("Skipping DHT bootstrap node %q: %v", node, err)
+					continue
+				}
+				addrs = append(addrs, dht.NewAddr(udpAddr))
+			}
+			if len(addrs) == 0 {
+				return nil, errors.New("no DHT bootstrap node resolved")
+			}
+			return addrs, nil
+		}
+	}
+}
+
 // Helper function to try to set a field value using reflection
 // This is a bit hacky but might help override the client's dialer
 func setValue(obj interface{}, fieldName string, value interface{}) {
@@ -315,46 +1135,294 @@ func init() {
 			JackettHost:    "",
 			JackettApiKey:  "",
 			YTSServerURL:   "https://yts.mx/api/v2/list_movies.json", // Default to YTS.mx
+
+			PrebufferSeconds:    defaultPrebufferSeconds,
+			StallTimeoutSeconds: defaultStallTimeoutSeconds,
+			StreamCORSOrigin:    "*",
+			MaxMagnetLength:     defaultMaxMagnetLength,
+			MaxUploadSize:       defaultMaxUploadSize,
+			PortRangeStart:      defaultPortRangeStart,
+			PortRangeEnd:        defaultPortRangeEnd,
+			DefaultSortBy:       defaultYTSSortBy,
+			DefaultOrderBy:      defaultYTSOrderBy,
+
+			ReadHeaderTimeoutSeconds: defaultReadHeaderTimeoutSeconds,
+			IdleTimeoutSeconds:       defaultIdleTimeoutSeconds,
+			APITimeoutSeconds:        defaultAPITimeoutSeconds,
+
+			MinReadaheadMB: defaultMinReadaheadMB,
+			MaxReadaheadMB: defaultMaxReadaheadMB,
+
+			BtsowMaxResults:        defaultBtsowMaxResults,
+			IdleConnCleanupSeconds: defaultIdleConnCleanupSeconds,
+			SeedDurationMinutes:    defaultSeedDurationMinutes,
 		}
-		// Create the config directory if it doesn't exist
-		if err := os.MkdirAll("config", 0755); err != nil {
-			log.Fatalf("Failed to create config directory: %v", err)
-		}
-		settingsFile, err := os.Create("config/settings.json")
-		if err != nil {
-			log.Fatalf("Failed to create settings.json: %v", err)
+		// Create the config directory if it doesn't exist. A read-only
+		// filesystem is fine when settings come from the environment.
+		if err := writeDefaultSettings(defaultSettings); err != nil {
+			log.Printf("Could not create settings.json, using defaults and environment: %v", err)
+		} else {
+			log.Println("Default settings created in settings.json")
 		}
+	}
+
+	// Load settings from settings.json, if there is one
+	var s Settings
+	settingsFile, err := os.Open("config/settings.json")
+	if err == nil {
 		defer settingsFile.Close()
-		encoder := json.NewEncoder(settingsFile)
-		encoder.SetIndent("", "  ")
-		if err := encoder.Encode(defaultSettings); err != nil {
-			log.Fatalf("Failed to encode default settings: %v", err)
+		if err := json.NewDecoder(settingsFile).Decode(&s); err != nil {
+			log.Fatalf("Failed to decode settings.json: %v", err)
 		}
-		log.Println("Default settings created in settings.json")
+	} else if !os.IsNotExist(err) {
+		log.Fatalf("Failed to open settings.json: %v", err)
 	}
 
-	// Load settings from settings.json
-	settingsFile, err := os.Open("config/settings.json")
+	// Environment variables take precedence over settings.json, but only
+	// the file's own values are ever written back to it
+	fileSettings = s
+	envSettingsFields = applySettingsFromEnv(&s)
+
+	applySettingsDefaults(&s)
+
+	// Generate an instance ID on first run and keep it, so the next start
+	// still recognizes this instance's temp dirs
+	generatedInstanceID := s.InstanceID == ""
+	if generatedInstanceID {
+		s.InstanceID = fmt.Sprintf("%08x", rand.Uint32())
+	}
+
+	settingsMutex.Lock()
+	currentSettings = s
+	if generatedInstanceID {
+		if err := saveSettingsToFile(); err != nil {
+			// A read-only config dir can't keep a random ID, so use one
+			// that is the same on every start from this config path
+			currentSettings.InstanceID = configInstanceID()
+			log.Printf("Failed to save instance ID, using %s derived from the config path: %v", currentSettings.InstanceID, err)
+		}
+		// Dirs from before instance IDs existed belong to no instance, so
+		// the run that picks this instance's ID removes them
+		sweepLegacyTempDirs = true
+	}
+	settingsMutex.Unlock()
+}
+
+// Set when this run picked the instance ID, see cleanupOldTempDirs
+var sweepLegacyTempDirs bool
+
+// Instance ID derived from the absolute config path, for when a generated
+// one can't be saved
+func configInstanceID() string {
+	path, err := filepath.Abs("config")
 	if err != nil {
-		log.Fatalf("Failed to open settings.json: %v", err)
+		path = "config"
+	}
+	sum := sha256.Sum256([]byte(path))
+	return hex.EncodeToString(sum[:4])
+}
+
+// Write the initial settings.json
+func writeDefaultSettings(defaults Settings) error {
+	if err := os.MkdirAll("config", 0755); err != nil {
+		return err
+	}
+	settingsFile, err := os.Create("config/settings.json")
+	if err != nil {
+		return err
 	}
 	defer settingsFile.Close()
+	encoder := json.NewEncoder(settingsFile)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(defaults)
+}
 
-	var s Settings
-	if err := json.NewDecoder(settingsFile).Decode(&s); err != nil {
-		log.Fatalf("Failed to decode settings.json: %v", err)
+// Prefix of the environment variables that override settings
+const settingsEnvPrefix = "BITPLAY_"
+
+// Settings as read from settings.json, and the fields the environment
+// overrides. Saves write those fields with their file values, so secrets
+// given in the environment stay out of the file and unsetting a variable
+// reverts the setting.
+var (
+	fileSettings      Settings
+	envSettingsFields []string
+)
+
+// Override settings from BITPLAY_* environment variables. Each field maps
+// to its name in upper snake case, e.g. ProxyURL to BITPLAY_PROXY_URL;
+// lists are comma-separated. Returns the names of the fields that were set.
+func applySettingsFromEnv(s *Settings) []string {
+	var applied []string
+	value := reflect.ValueOf(s).Elem()
+	fields := value.Type()
+	for i := 0; i < fields.NumField(); i++ {
+		name := settingsEnvPrefix + envName(fields.Field(i).Name)
+		raw, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		field := value.Field(i)
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(raw)
+		case reflect.Bool:
+			parsed, err := strconv.ParseBool(raw)
+			if err != nil {
+				log.Printf("Ignoring %s: %v", name, err)
+				continue
+			}
+			field.SetBool(parsed)
+		case reflect.Int, reflect.Int64:
+			parsed, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil {
+				log.Printf("Ignoring %s: %v", name, err)
+				continue
+			}
+			field.SetInt(parsed)
+		case reflect.Slice:
+			var items []string
+			for _, item := range strings.Split(raw, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			field.Set(reflect.ValueOf(items))
+		default:
+			continue
+		}
+		log.Printf("Using %s from the environment", name)
+		applied = append(applied, fields.Field(i).Name)
+	}
+	return applied
+}
+
+// s with every environment-sourced field put back to its settings.json value
+func withoutEnvSettings(s Settings) Settings {
+	value := reflect.ValueOf(&s).Elem()
+	fromFile := reflect.ValueOf(fileSettings)
+	for _, name := range envSettingsFields {
+		value.FieldByName(name).Set(fromFile.FieldByName(name))
+	}
+	return s
+}
+
+// Upper snake case of a Go field name, keeping acronyms together:
+// YTSServerURL becomes YTS_SERVER_URL
+func envName(fieldName string) string {
+	runes := []rune(fieldName)
+	var b strings.Builder
+	for i, c := range runes {
+		if i > 0 && unicode.IsUpper(c) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(c))
 	}
+	return b.String()
+}
 
+// Fill in defaults for unset settings and replace invalid ones
+func applySettingsDefaults(s *Settings) {
 	// Set default YTS server URL if not set
 	if s.YTSServerURL == "" {
 		s.YTSServerURL = "https://yts.mx/api/v2/list_movies.json"
 	}
 
-	settingsMutex.Lock()
-	currentSettings = s
-	settingsMutex.Unlock()
+	// Set default prebuffer if not set
+	if s.PrebufferSeconds <= 0 {
+		s.PrebufferSeconds = defaultPrebufferSeconds
+	}
+
+	// Set default stall timeout if not set
+	if s.StallTimeoutSeconds <= 0 {
+		s.StallTimeoutSeconds = defaultStallTimeoutSeconds
+	}
+
+	// Allow any origin to stream by default
+	if s.StreamCORSOrigin == "" {
+		s.StreamCORSOrigin = "*"
+	}
+
+	s.AllowedStreamExtensions = normalizeExtensions(s.AllowedStreamExtensions)
+
+	// Set default magnet length limit if not set
+	if s.MaxMagnetLength <= 0 {
+		s.MaxMagnetLength = defaultMaxMagnetLength
+	}
+
+	// Set default upload size limit if not set
+	if s.MaxUploadSize <= 0 {
+		s.MaxUploadSize = defaultMaxUploadSize
+	}
+
+	// Fall back to the default port range if it is unset or invalid
+	if err := validatePortRange(s.PortRangeStart, s.PortRangeEnd); err != nil {
+		if s.PortRangeStart != 0 || s.PortRangeEnd != 0 {
+			log.Printf("Ignoring port range: %v", err)
+		}
+		s.PortRangeStart = defaultPortRangeStart
+		s.PortRangeEnd = defaultPortRangeEnd
+	}
+
+	// Fall back to the default YTS browse order if it is unset or invalid
+	if err := validateYTSSort(s.DefaultSortBy, s.DefaultOrderBy); err != nil {
+		if s.DefaultSortBy != "" || s.DefaultOrderBy != "" {
+			log.Printf("Ignoring default YTS sort: %v", err)
+		}
+		s.DefaultSortBy = defaultYTSSortBy
+		s.DefaultOrderBy = defaultYTSOrderBy
+	}
+
+	// Set default server timeouts if not set
+	if s.ReadHeaderTimeoutSeconds <= 0 {
+		s.ReadHeaderTimeoutSeconds = defaultReadHeaderTimeoutSeconds
+	}
+	if s.IdleTimeoutSeconds <= 0 {
+		s.IdleTimeoutSeconds = defaultIdleTimeoutSeconds
+	}
+	if s.APITimeoutSeconds <= 0 {
+		s.APITimeoutSeconds = defaultAPITimeoutSeconds
+	}
+
+	// Set default readahead bounds if not set, keeping max at least min
+	if s.MinReadaheadMB <= 0 {
+		s.MinReadaheadMB = defaultMinReadaheadMB
+	}
+	if s.MaxReadaheadMB <= 0 {
+		s.MaxReadaheadMB = defaultMaxReadaheadMB
+	}
+	s.MaxReadaheadMB = max(s.MaxReadaheadMB, s.MinReadaheadMB)
+
+	// Set default btsow result cap if not set
+	if s.BtsowMaxResults <= 0 {
+		s.BtsowMaxResults = defaultBtsowMaxResults
+	}
+
+	// Set default idle connection cleanup interval if not set
+	if s.IdleConnCleanupSeconds <= 0 {
+		s.IdleConnCleanupSeconds = defaultIdleConnCleanupSeconds
+	}
+
+	// Set default seeding grace period if not set
+	if s.SeedDurationMinutes <= 0 {
+		s.SeedDurationMinutes = defaultSeedDurationMinutes
+	}
+}
+
+// Prefix of the temp dirs this instance creates for torrent data
+func tempDirPrefix() string {
+	settingsMutex.RLock()
+	defer settingsMutex.RUnlock()
+	return legacyTempDirPrefix + currentSettings.InstanceID + "-"
 }
 
+const legacyTempDirPrefix = "bitplay-torrent-"
+
 // Initialize SQLite database for favorites
 func initDatabase() error {
 	// Create database in config directory
@@ -377,6 +1445,12 @@ func initDatabase() error {
 		return fmt.Errorf("failed to set WAL mode: %w", err)
 	}
 
+	// Wait for locks to clear instead of failing straight away with SQLITE_BUSY
+	_, err = db.Exec(fmt.Sprintf("PRAGMA busy_timeout=%d;", sqliteBusyTimeoutMs))
+	if err != nil {
+		return fmt.Errorf("failed to set busy timeout: %w", err)
+	}
+
 	// Create favorites table
 	createTableSQL := `CREATE TABLE IF NOT EXISTS favorites (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -400,32 +1474,75 @@ func initDatabase() error {
 	return nil
 }
 
+// Run a favorites write, retrying when SQLite reports the database is busy
+func execWithRetry(query string, args ...interface{}) (sql.Result, error) {
+	var result sql.Result
+	var err error
+	for attempt := 1; attempt <= favoritesWriteRetries; attempt++ {
+		result, err = db.Exec(query, args...)
+		if !isSQLiteBusy(err) {
+			return result, err
+		}
+		log.Printf("Database busy, retrying write (attempt %d/%d)", attempt, favoritesWriteRetries)
+		time.Sleep(time.Duration(attempt) * favoritesRetryDelay)
+	}
+	return result, err
+}
+
+// Whether err is SQLITE_BUSY, including its extended result codes
+func isSQLiteBusy(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code()&0xff == sqlite3.SQLITE_BUSY
+}
+
 // Clean up old temp directories from previous runs
 func cleanupOldTempDirs() {
 	tempDir := os.TempDir()
+	prefix := tempDirPrefix()
 	entries, err := os.ReadDir(tempDir)
 	if err != nil {
 		return
 	}
 
 	for _, entry := range entries {
-		// Look for our temp directories (bitplay-torrent-*)
-		if entry.IsDir() && strings.HasPrefix(entry.Name(), "bitplay-torrent-") {
+		// Look for our temp directories (bitplay-torrent-<instanceId>-*),
+		// leaving those of other instances on this host alone
+		if entry.IsDir() && (strings.HasPrefix(entry.Name(), prefix) || sweepLegacyTempDirs && isLegacyTempDir(entry.Name())) {
 			fullPath := filepath.Join(tempDir, entry.Name())
 			os.RemoveAll(fullPath)
 		}
 	}
+	sweepLegacyTempDirs = false
+}
+
+// Temp dirs from before instance IDs were named bitplay-torrent-<digits>,
+// with no instance ID segment
+func isLegacyTempDir(name string) bool {
+	suffix, ok := strings.CutPrefix(name, legacyTempDirPrefix)
+	if !ok || suffix == "" {
+		return false
+	}
+	_, err := strconv.ParseUint(suffix, 10, 64)
+	return err == nil
 }
 
 func main() {
 	// Seed random number generator
 	rand.Seed(time.Now().UnixNano())
 
-	// Initialize favorites database
-	if err := initDatabase(); err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+	// Initialize favorites database, unless favorites are turned off
+	// (db stays nil and the favorites endpoints answer 501)
+	settingsMutex.RLock()
+	disableFavorites := currentSettings.DisableFavorites
+	settingsMutex.RUnlock()
+	if disableFavorites {
+		log.Println("Favorites are disabled, not opening the database.")
+	} else {
+		if err := initDatabase(); err != nil {
+			log.Fatalf("Failed to initialize database: %v", err)
+		}
+		defer db.Close()
 	}
-	defer db.Close()
 
 	// Clean up any leftover temp directories from previous runs
 	cleanupOldTempDirs()
@@ -435,27 +1552,41 @@ func main() {
 
 	// Set up endpoint handlers
 	http.HandleFunc("/api/v1/torrent/add", addTorrentHandler)
+	http.HandleFunc("/api/v1/torrent/add-async", addTorrentAsyncHandler)
+	http.HandleFunc("/api/v1/torrent/reset", resetSessionsHandler)
+	http.HandleFunc("/api/v1/torrent/resolve", resolveTorrentURLHandler)
+	http.HandleFunc("/api/v1/torrent/infohash", infohashHandler)
+	http.HandleFunc("/api/v1/torrent/health", torrentHealthHandler)
 	http.HandleFunc("/api/v1/torrent/", torrentHandler)
-	http.HandleFunc("/api/v1/settings", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodGet {
-			settingsMutex.RLock()
-			defer settingsMutex.RUnlock()
-			respondWithJSON(w, http.StatusOK, currentSettings)
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
+	http.HandleFunc("/api/v1/stream", streamMagnetHandler)
+	http.HandleFunc("/api/v1/disk", diskUsageHandler)
+	http.HandleFunc("/api/v1/ports", portsHandler)
+	http.HandleFunc("/api/v1/settings", getSettingsHandler)
+	http.HandleFunc("/api/v1/config", effectiveConfigHandler)
+	http.HandleFunc("/api/v1/settings/export", exportSettingsHandler)
+	http.HandleFunc("/api/v1/settings/import", importSettingsHandler)
 	http.HandleFunc("/api/v1/settings/proxy", saveProxySettingsHandler)
 	http.HandleFunc("/api/v1/settings/prowlarr", saveProwlarrSettingsHandler)
 	http.HandleFunc("/api/v1/settings/jackett", saveJackettSettingsHandler)
 	http.HandleFunc("/api/v1/settings/yts", saveYTSSettingsHandler)
+	http.HandleFunc("/api/v1/settings/blocklist", saveBlocklistSettingsHandler)
+	http.HandleFunc("/api/v1/settings/ports", savePortRangeSettingsHandler)
+	http.HandleFunc("/api/v1/settings/yts-sort", saveYTSSortSettingsHandler)
+	http.HandleFunc("/api/v1/settings/stream-extensions", saveStreamExtensionSettingsHandler)
 	http.HandleFunc("/api/v1/prowlarr/search", searchFromProwlarr)
 	http.HandleFunc("/api/v1/jackett/search", searchFromJackett)
 	http.HandleFunc("/api/v1/prowlarr/test", testProwlarrConnection)
 	http.HandleFunc("/api/v1/jackett/test", testJackettConnection)
 	http.HandleFunc("/api/v1/proxy/test", testProxyConnection)
+	http.HandleFunc("/api/v1/indexers/test", testAllIndexersHandler)
 	http.HandleFunc("/api/v1/torrent/convert", convertTorrentToMagnetHandler)
+	http.HandleFunc("/api/v1/torrent/inspect-file", inspectTorrentFileHandler)
+	http.HandleFunc("/api/v1/streamable-extensions", streamableExtensionsHandler)
+	http.HandleFunc("/api/v1/trackers/refresh", refreshTrackersHandler)
 	http.HandleFunc("/api/v1/yts/movies", fetchYTSMovies)
+	http.HandleFunc("/api/v1/yts/cache/clear", clearYTSCacheHandler)
+	http.HandleFunc("/api/v1/yts/genres", ytsGenresHandler)
+	http.HandleFunc("/api/v1/yts/magnet", ytsMagnetHandler)
 	http.HandleFunc("/api/v1/avmoo/movies", fetchAvmooMovies)
 	http.HandleFunc("/api/v1/avmoo/movie/", fetchAvmooMovieDetail)
 
@@ -463,9 +1594,20 @@ func main() {
 	http.HandleFunc("/api/v1/favorites", favoritesHandler)
 	http.HandleFunc("/api/v1/favorites/add", addFavoriteHandler)
 	http.HandleFunc("/api/v1/favorites/remove/", removeFavoriteHandler)
+	http.HandleFunc("/api/v1/favorites/prune", pruneFavoritesHandler)
+	http.HandleFunc("/api/v1/favorites/import", importFavoritesHandler)
+	http.HandleFunc("/api/v1/favorites/status", favoritesStatusHandler)
+	http.HandleFunc("/api/v1/discover", discoverHandler)
 
 	// Set up client file serving
-	http.Handle("/", http.FileServer(http.Dir("./client")))
+	settingsMutex.RLock()
+	disableSPAFallback := currentSettings.DisableSPAFallback
+	settingsMutex.RUnlock()
+	if disableSPAFallback {
+		http.Handle("/", http.FileServer(http.Dir("./client")))
+	} else {
+		http.Handle("/", spaHandler("./client"))
+	}
 	http.HandleFunc("/client/", func(w http.ResponseWriter, r *http.Request) {
 		http.StripPrefix("/client/", http.FileServer(http.Dir("./client"))).ServeHTTP(w, r)
 	})
@@ -474,24 +1616,32 @@ func main() {
 	})
 
 	go cleanupSessions()
+	go refreshTrackersPeriodically()
+	go trackBandwidth()
+	go watchStalls()
+	go closeIdleHTTPConnections()
 
 	port := 3147
 
 	addr := fmt.Sprintf("0.0.0.0:%d", port)
+	serverAddr = addr
 
 	// Create channel to signal if server started successfully
 	serverStarted := make(chan bool, 1)
 
 	// Create a server with graceful shutdown
-	server := &http.Server{
-		Addr:    addr,
-		Handler: nil, // Use the default ServeMux
+	server := newServer(addr, http.DefaultServeMux)
+
+	scheme := "http"
+	if tlsConfigured() {
+		scheme = "https"
 	}
+	localBaseURL = fmt.Sprintf("%s://127.0.0.1:%d", scheme, port)
 
 	// Start the server in a goroutine
 	go func() {
-		err := server.ListenAndServe()
-		if err != nil && err != http.ErrServerClosed {
+		if err := listenAndServe(server); err != nil && err != http.ErrServerClosed {
+			log.Printf("Server error: %v", err)
 			serverStarted <- false
 		}
 	}()
@@ -506,7 +1656,7 @@ func main() {
 		// No immediate error, assume it started successfully
 		fmt.Printf("\n------------------------------------------------\n")
 		fmt.Printf("✅ Server started! Open in your browser:\n")
-		fmt.Printf("   http://localhost:%d\n", port)
+		fmt.Printf("   %s://localhost:%d\n", scheme, port)
 		fmt.Printf("------------------------------------------------\n\n")
 
 		// Block forever (the server is running in a goroutine)
@@ -514,11 +1664,172 @@ func main() {
 	}
 }
 
+// Server for addr that runs handler behind the request ID, panic recovery
+// and API timeout middleware. A server-wide WriteTimeout would cut off
+// long video streams, so only header reads and idle connections are
+// bounded here; API requests get their own deadline from
+// apiTimeoutMiddleware.
+func newServer(addr string, handler http.Handler) *http.Server {
+	settingsMutex.RLock()
+	readHeaderTimeout := time.Duration(currentSettings.ReadHeaderTimeoutSeconds) * time.Second
+	idleTimeout := time.Duration(currentSettings.IdleTimeoutSeconds) * time.Second
+	settingsMutex.RUnlock()
+
+	return &http.Server{
+		Addr:              addr,
+		Handler:           requestIDMiddleware(recoverMiddleware(apiTimeoutMiddleware(handler))),
+		ReadHeaderTimeout: readHeaderTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+}
+
+// Whether both a TLS certificate and key are configured
+func tlsConfigured() bool {
+	settingsMutex.RLock()
+	defer settingsMutex.RUnlock()
+	return currentSettings.TLSCertFile != "" && currentSettings.TLSKeyFile != ""
+}
+
+// Serve HTTPS only when both a certificate and key are configured.
+// ListenAndServeTLS negotiates HTTP/2 with clients that support it.
+func listenAndServe(server *http.Server) error {
+	settingsMutex.RLock()
+	tlsCertFile := currentSettings.TLSCertFile
+	tlsKeyFile := currentSettings.TLSKeyFile
+	settingsMutex.RUnlock()
+
+	if tlsCertFile == "" || tlsKeyFile == "" {
+		return server.ListenAndServe()
+	}
+	return server.ListenAndServeTLS(tlsCertFile, tlsKeyFile)
+}
+
+// Serve files from dir, answering frontend routes such as /movie/123 with
+// index.html so the client-side router can handle them on a reload.
+// Unknown API paths and missing assets (anything with an extension) still
+// get a 404.
+func spaHandler(dir string) http.Handler {
+	fileServer := http.FileServer(http.Dir(dir))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		urlPath := path.Clean("/" + r.URL.Path)
+		if strings.HasPrefix(urlPath, "/api/") || path.Ext(urlPath) != "" {
+			fileServer.ServeHTTP(w, r)
+			return
+		}
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(urlPath))); err == nil {
+			fileServer.ServeHTTP(w, r)
+			return
+		}
+		http.ServeFile(w, r, filepath.Join(dir, "index.html"))
+	})
+}
+
+// Header carrying the ID that ties a request's log lines together
+const requestIDHeader = "X-Request-Id"
+
+type requestLoggerKey struct{}
+
+// Tag every request with an ID, reusing one the client sent, and give the
+// handlers a logger that prefixes each line with it
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = fmt.Sprintf("%016x", rand.Uint64())
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		logger := log.New(log.Writer(), log.Prefix()+"[req "+id+"] ", log.Flags()|log.Lmsgprefix)
+		ctx := context.WithValue(r.Context(), requestLoggerKey{}, logger)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Accept client-supplied IDs only if they are short and can't forge log lines
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// Logger tagged with the request ID carried by ctx, or the standard logger
+func requestLogger(ctx context.Context) *log.Logger {
+	if logger, ok := ctx.Value(requestLoggerKey{}).(*log.Logger); ok {
+		return logger
+	}
+	return log.Default()
+}
+
+// Session subroutes that stream or run for as long as the client watches
+var longRunningTorrentRoutes = map[string]bool{
+	"stream":         true,
+	"stream-by-name": true,
+	"events":         true,
+	"clip":           true,
+	"mediainfo":      true,
+	"playable":       true,
+}
+
+// Whether a request streams or may legitimately run longer than the API
+// timeout, like a synchronous add waiting for metadata
+func isLongRunningRequest(r *http.Request) bool {
+	switch r.URL.Path {
+	case "/api/v1/stream", "/api/v1/torrent/add", "/api/v1/favorites/prune":
+		return true
+	}
+	// /api/v1/torrent/{sessionId}/{route}/...
+	parts := strings.Split(r.URL.Path, "/")
+	return len(parts) > 5 && parts[3] == "torrent" && longRunningTorrentRoutes[parts[5]]
+}
+
+// Give API requests a read and write deadline so a stalled client can't
+// hold a connection forever. Streams are left without one.
+func apiTimeoutMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isLongRunningRequest(r) {
+			settingsMutex.RLock()
+			timeout := time.Duration(currentSettings.APITimeoutSeconds) * time.Second
+			settingsMutex.RUnlock()
+
+			deadline := time.Now().Add(timeout)
+			rc := http.NewResponseController(w)
+			rc.SetReadDeadline(deadline)
+			rc.SetWriteDeadline(deadline)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Recover from panics in any handler, log the stack and answer with a 500
+// so one bad request can't take the server down
+func recoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				// Let net/http handle deliberate aborts of the response
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+				requestLogger(r.Context()).Printf("Panic serving %s %s: %v\n%s", r.Method, r.URL.Path, rec, debug.Stack())
+				respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
 // Set up global proxy for all Go HTTP calls
 func setGlobalProxy() {
 	settingsMutex.RLock()
 	enableProxy := currentSettings.EnableProxy
 	proxyURL := currentSettings.ProxyURL
+	chain := proxyChain()
 	settingsMutex.RUnlock()
 
 	if !enableProxy {
@@ -526,7 +1837,7 @@ func setGlobalProxy() {
 		return
 	}
 
-	proxyDialer, err := createProxyDialer(proxyURL)
+	proxyDialer, err := createProxyDialer(chain...)
 	if err != nil {
 		log.Printf("Warning: Could not create proxy dialer: %v", err)
 		return
@@ -545,8 +1856,33 @@ func setGlobalProxy() {
 
 // Handler to add a torrent using a magnet link
 func addTorrentHandler(w http.ResponseWriter, r *http.Request) {
-	var request struct{ Magnet string }
+	addTorrent(w, r, false)
+}
+
+// Handler for POST /api/v1/torrent/add-async
+// Returns the session ID straight away; poll /api/v1/torrent/{sessionId}/status
+// until the metadata is ready before using the session.
+func addTorrentAsyncHandler(w http.ResponseWriter, r *http.Request) {
+	addTorrent(w, r, true)
+}
+
+func addTorrent(w http.ResponseWriter, r *http.Request, async bool) {
+	var request struct {
+		Magnet string
+		Files  []int  // Optional: indices of the files to download, all if empty
+		Name   string // Optional: name shown until the metadata arrives, for magnets without dn
+	}
+	// Don't buffer an oversized body just to reject its magnet afterwards
+	settingsMutex.RLock()
+	maxBody := int64(currentSettings.MaxMagnetLength) + addTorrentBodySlack
+	settingsMutex.RUnlock()
+	r.Body = http.MaxBytesReader(w, r.Body, maxBody)
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondWithJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": "Request body too large"})
+			return
+		}
 		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request"})
 		return
 	}
@@ -555,70 +1891,65 @@ func addTorrentHandler(w http.ResponseWriter, r *http.Request) {
 	if magnet == "" {
 		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "No magnet link provided"})
 	}
+	if err := checkMagnetLength(magnet); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
 
 	// handle http links like Prowlarr or Jackett
+	// Indexers either redirect to a magnet or serve the .torrent file itself
+	var mi *metainfo.MetaInfo
 	if strings.HasPrefix(request.Magnet, "http") {
-		// Use the client that bypasses proxy for Prowlarr
-		httpClient := createSelectiveProxyClient()
-
-		httpClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse
-		}
-
-		// Make the HTTP request to follow the Prowlarr link
-		req, err := http.NewRequest("GET", request.Magnet, nil)
+		resolved, torrentFile, err := resolveMagnetFromURL(request.Magnet)
 		if err != nil {
-			log.Printf("Error creating request: %v", err)
-			respondWithJSON(w, http.StatusBadRequest, map[string]string{
-				"error": "Invalid URL: " + err.Error(),
-			})
+			respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
 			return
 		}
+		magnet, mi = resolved, torrentFile
+	}
 
-		req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
-
-		// Follow the Prowlarr link
-		log.Printf("Following Prowlarr URL: %s", request.Magnet)
-		resp, err := httpClient.Do(req)
-		if err != nil {
-			log.Printf("Error following URL: %v", err)
-			respondWithJSON(w, http.StatusBadRequest, map[string]string{
-				"error": "Failed to download: " + err.Error(),
-			})
+	if mi != nil {
+		// Refuse blocked content and strip blocked trackers
+		if err := applyBlocklistsToMetaInfo(mi); err != nil {
+			respondWithJSON(w, http.StatusForbidden, map[string]string{"error": err.Error()})
+			return
+		}
+	} else {
+		// check if magnet link is valid
+		if magnet == "" || !strings.HasPrefix(magnet, "magnet:") {
+			respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid magnet link"})
 			return
 		}
-		defer resp.Body.Close()
-
-		log.Printf("Got response: %d %s", resp.StatusCode, resp.Status)
-
-		// Check for redirects to magnet links
-		if resp.StatusCode >= 300 && resp.StatusCode < 400 {
-			location := resp.Header.Get("Location")
-			log.Printf("Found redirect to: %s", location)
 
-			if strings.HasPrefix(location, "magnet:") {
-				log.Printf("Found magnet redirect: %s", location)
-				magnet = location
-			} else {
-				log.Printf("Non-magnet redirect: %s", location)
-				respondWithJSON(w, http.StatusBadRequest, map[string]string{
-					"error": "URL redirects to non-magnet content",
-				})
-				return
-			}
+		// Refuse blocked content and strip blocked trackers
+		var err error
+		magnet, err = applyBlocklists(magnet)
+		if errors.Is(err, errBlockedInfohash) {
+			respondWithJSON(w, http.StatusForbidden, map[string]string{"error": err.Error()})
+			return
+		} else if err != nil {
+			respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid magnet url"})
+			return
 		}
 	}
 
-	// check if magnet link is valid
-	if magnet == "" || !strings.HasPrefix(magnet, "magnet:") {
-		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid magnet link"})
-		return
+	// UDP trackers fail silently behind the SOCKS5 proxy, so say so
+	var trackerWarning string
+	if mi == nil {
+		magnet, trackerWarning = adaptTrackersForProxy(magnet)
+		if trackerWarning != "" {
+			requestLogger(r.Context()).Printf("%s", trackerWarning)
+		}
 	}
 
 	// Use the simpler, more secure proxy configuration
 	client, port, tempDir, err := initTorrentWithProxy()
-	if err != nil {
-		log.Printf("Client creation error: %v", err)
+	if errors.Is(err, errNoListenPort) {
+		requestLogger(r.Context()).Printf("Client creation error: %v", err)
+		respondWithJSON(w, http.StatusServiceUnavailable, map[string]string{"error": errNoListenPort.Error()})
+		return
+	} else if err != nil {
+		requestLogger(r.Context()).Printf("Client creation error: %v", err)
 		respondWithJSON(w, http.StatusInternalServerError,
 			map[string]string{"error": "Failed to create client with proxy"})
 		return
@@ -636,862 +1967,4577 @@ func addTorrentHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	t, err := client.AddMagnet(magnet)
-	if err != nil {
-		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid magnet url"})
+	var t *torrent.Torrent
+	if mi != nil {
+		t, err = client.AddTorrent(mi)
+		if err != nil {
+			respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid torrent file"})
+			return
+		}
+	} else {
+		t, err = client.AddMagnet(magnet)
+		if err != nil {
+			respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid magnet url"})
+			return
+		}
+	}
+
+	// A dn in the magnet wins; the real name replaces either once the info arrives
+	if request.Name != "" && !hasDisplayName(t) {
+		t.SetDisplayName(request.Name)
+	}
+
+	if async {
+		sessionID := t.InfoHash().HexString()
+		session := &TorrentSession{
+			Client:      client,
+			Torrent:     t,
+			Port:        port,
+			LastUsed:    time.Now(),
+			TempDataDir: tempDir, // Store temp dir for cleanup
+			CreatedAt:   time.Now(),
+			SeedGrace:   seedGracePeriod(),
+		}
+		session.metadataLoading.Store(true)
+
+		// Keep a session that is already open for this torrent; the new
+		// client is closed by the deferred cleanup
+		if existing, loaded := sessions.LoadOrStore(sessionID, session); loaded {
+			metadata := "ready"
+			if existing.metadataLoading.Load() {
+				metadata = "loading"
+			}
+			respondWithJSON(w, http.StatusAccepted, map[string]string{
+				"sessionId": sessionID,
+				"metadata":  metadata,
+				"name":      existing.Torrent.Name(),
+			})
+			return
+		}
+		failedSessions.Delete(sessionID)
+		client = nil
+
+		go waitForMetadata(sessionID, session, request.Files)
+
+		response := map[string]string{
+			"sessionId": sessionID,
+			"metadata":  "loading",
+			"name":      t.Name(),
+		}
+		if trackerWarning != "" {
+			response["warning"] = trackerWarning
+		}
+		respondWithJSON(w, http.StatusAccepted, response)
 		return
 	}
+
 	select {
 	case <-t.GotInfo():
-	case <-time.After(3 * time.Minute):
+	case <-time.After(metadataTimeout):
 		respondWithJSON(w, http.StatusGatewayTimeout, map[string]string{"error": "Timeout getting info - proxy might be blocking BitTorrent traffic"})
+		return
+	}
+
+	// Only fetch the files the user asked for
+	if len(request.Files) > 0 {
+		if err := selectFiles(t, request.Files); err != nil {
+			respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
 	}
 
 	sessionID := t.InfoHash().HexString()
-	sessions.Store(sessionID, &TorrentSession{
+	session, loaded := sessions.LoadOrStore(sessionID, &TorrentSession{
 		Client:      client,
 		Torrent:     t,
 		Port:        port,
 		LastUsed:    time.Now(),
 		TempDataDir: tempDir, // Store temp dir for cleanup
+		CreatedAt:   time.Now(),
+		SeedGrace:   seedGracePeriod(),
 	})
+	if loaded {
+		// Another add got there first; keep its session and let the
+		// deferred cleanup close this client
+		respondWithJSON(w, http.StatusOK, map[string]string{
+			"sessionId": sessionID,
+			"name":      session.Torrent.Name(),
+		})
+		return
+	}
 
 	// Set client to nil so it doesn't get closed by the defer function
 	// since it's now stored in the sessions map
 	client = nil
 
-	respondWithJSON(w, http.StatusOK, map[string]string{"sessionId": sessionID})
+	response := map[string]string{
+		"sessionId": sessionID,
+		"name":      t.Name(),
+	}
+	if trackerWarning != "" {
+		response["warning"] = trackerWarning
+	}
+	respondWithJSON(w, http.StatusOK, response)
 }
 
-// Torrent handler to serve torrent files and stream content
-func torrentHandler(w http.ResponseWriter, r *http.Request) {
-	// Extract sessionId and possibly fileIndex from the URL
-	parts := strings.Split(r.URL.Path, "/")
+// HTTP(S) announce URLs of trackers that also run a UDP endpoint, by the
+// UDP tracker's host:port
+var udpTrackerHTTPEquivalents = map[string]string{
+	"tracker.opentrackr.org:1337": "http://tracker.opentrackr.org:1337/announce",
+}
 
-	// The URL structure is /api/v1/torrent/[sessionId]/...
-	if len(parts) < 5 {
-		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid path"})
-		return
+// With the proxy on, UDP trackers can't be reached because SOCKS5 only
+// carries TCP here. Returns the magnet, with its UDP trackers replaced by
+// known HTTP(S) equivalents or dropped if StripUDPTrackersWithProxy is set,
+// and a warning when any were found. A magnet left without trackers gets
+// the default trackers that have an HTTP(S) form.
+func adaptTrackersForProxy(magnet string) (string, string) {
+	settingsMutex.RLock()
+	enableProxy := currentSettings.EnableProxy
+	strip := currentSettings.StripUDPTrackersWithProxy
+	settingsMutex.RUnlock()
+	if !enableProxy {
+		return magnet, ""
 	}
 
-	// The session ID is at position 4
-	sessionID := parts[4]
-
-	// Get the torrent session from our sessions map
-	sessionValue, ok := sessions.Load(sessionID)
-	if !ok {
-		respondWithJSON(w, http.StatusNotFound, map[string]string{
-			"error": "Session not found",
-			"id":    sessionID,
-		})
-		return
+	m, err := metainfo.ParseMagnetUri(magnet)
+	if err != nil {
+		return magnet, ""
 	}
-	session := sessionValue.(*TorrentSession)
-	session.LastUsed = time.Now() // Update last used time
-
-	// If there's a streaming request, handle it
-	if len(parts) > 5 && parts[5] == "stream" { // Changed from parts[4] to parts[5]
-		if len(parts) < 7 { // Changed from 6 to 7
-			http.Error(w, "Invalid stream path", http.StatusBadRequest)
-			return
-		}
-
-		fileIndexString := parts[6]
-		// remove .vtt from fileIndex if it exists
-		fileIndexString = strings.TrimSuffix(fileIndexString, ".vtt")
-
-		fileIndex, err := strconv.Atoi(fileIndexString)
-
-		if err != nil {
-			http.Error(w, "Invalid file index", http.StatusBadRequest)
-			return
+	var udpTrackers, otherTrackers []string
+	for _, trackerURL := range m.Trackers {
+		if isUDPTracker(trackerURL) {
+			udpTrackers = append(udpTrackers, trackerURL)
+		} else {
+			otherTrackers = append(otherTrackers, trackerURL)
 		}
+	}
+	if len(udpTrackers) == 0 {
+		return magnet, ""
+	}
 
-		if fileIndex < 0 || fileIndex >= len(session.Torrent.Files()) {
-			http.Error(w, "File index out of range", http.StatusBadRequest)
-			return
+	if !strip {
+		if len(otherTrackers) == 0 {
+			return magnet, fmt.Sprintf("All %d trackers are UDP and can't be reached through the proxy; add HTTP(S) trackers or rely on DHT", len(udpTrackers))
 		}
+		return magnet, fmt.Sprintf("%d UDP trackers can't be reached through the proxy; only its HTTP(S) trackers will be used", len(udpTrackers))
+	}
 
-		file := session.Torrent.Files()[fileIndex]
-
-		// Set appropriate Content-Type based on file extension
-		fileName := file.DisplayPath()
-		extension := strings.ToLower(filepath.Ext(fileName))
-
-		switch extension {
-		case ".mp4":
-			w.Header().Set("Content-Type", "video/mp4")
-		case ".webm":
-			w.Header().Set("Content-Type", "video/webm")
-		case ".mkv":
-			w.Header().Set("Content-Type", "video/x-matroska")
-		case ".avi":
-			w.Header().Set("Content-Type", "video/x-msvideo")
-		case ".srt":
-			// For SRT, convert to VTT on-the-fly if requested as VTT
-			if r.URL.Query().Get("format") == "vtt" {
-				w.Header().Set("Content-Type", "text/vtt")
-				w.Header().Set("Access-Control-Allow-Origin", "*") // Allow cross-origin requests
-
-				// Read the SRT file with size limit
-				reader := file.NewReader()
-				// Wrap with limiting reader to prevent memory issues (10MB max)
-				limitReader := io.LimitReader(reader, 10*1024*1024) // 10MB limit for subtitles
-				srtBytes, err := io.ReadAll(limitReader)
-				if err != nil {
-					http.Error(w, "Failed to read subtitle file", http.StatusInternalServerError)
-					return
-				}
-
-				// Convert from SRT to VTT
-				vttBytes := convertSRTtoVTT(srtBytes)
-				w.Write(vttBytes)
-				return
-			} else {
-				w.Header().Set("Content-Type", "text/plain")
-				w.Header().Set("Access-Control-Allow-Origin", "*") // Allow cross-origin requests
+	otherTrackers = appendHTTPTrackerEquivalents(otherTrackers, udpTrackers)
+	if len(otherTrackers) == 0 {
+		// The built-in list is mostly UDP, so convert it the same way
+		for _, trackerURL := range defaultTrackers() {
+			if !isUDPTracker(trackerURL) && !slices.Contains(otherTrackers, trackerURL) {
+				otherTrackers = append(otherTrackers, trackerURL)
 			}
-		case ".vtt":
-			w.Header().Set("Content-Type", "text/vtt")
-			w.Header().Set("Access-Control-Allow-Origin", "*") // Allow cross-origin requests
-		case ".sub":
-			w.Header().Set("Content-Type", "text/plain")
-			w.Header().Set("Access-Control-Allow-Origin", "*") // Allow cross-origin requests
-		default:
-			w.Header().Set("Content-Type", "application/octet-stream")
 		}
-
-		// Add CORS headers for all content
-		// Stream the file
-		reader := file.NewReader()
-		// ServeContent will close the reader when done but we need to
-		// ensure it gets closed if there's a panic or other error
-		defer func() {
-			if closer, ok := reader.(io.Closer); ok {
-				closer.Close()
-				println("Closed reader***************************************")
-			}
-		}()
-		println("Serving content*****************************************")
-		http.ServeContent(w, r, fileName, time.Time{}, reader)
-		return
-	}
-
-	// If we get here, just return file list
-	var files []map[string]interface{}
-	for i, file := range session.Torrent.Files() {
-		files = append(files, map[string]interface{}{
-			"index": i,
-			"name":  file.DisplayPath(),
-			"size":  file.Length(),
-		})
+		otherTrackers = appendHTTPTrackerEquivalents(otherTrackers, defaultTrackers())
 	}
-
-	respondWithJSON(w, http.StatusOK, files)
+	m.Trackers = filterBlockedTrackers(otherTrackers)
+	return m.String(), fmt.Sprintf("Removed %d UDP trackers, which can't be reached through the proxy", len(udpTrackers))
 }
 
-// Add a function to convert SRT to VTT format
-func convertSRTtoVTT(srtBytes []byte) []byte {
-	srtContent := string(srtBytes)
-
-	// Add VTT header
-	vttContent := "WEBVTT\n\n"
-
-	// Convert SRT content to VTT format
-	// Simple conversion - replace timestamps format
-	lines := strings.Split(srtContent, "\n")
-
-	for i := 0; i < len(lines); i++ {
-		line := lines[i]
-
-		// Skip subtitle numbers
-		if _, err := strconv.Atoi(strings.TrimSpace(line)); err == nil {
+// Append the known HTTP(S) equivalents of the UDP trackers among
+// trackerURLs that aren't in trackers yet
+func appendHTTPTrackerEquivalents(trackers, trackerURLs []string) []string {
+	for _, trackerURL := range trackerURLs {
+		if !isUDPTracker(trackerURL) {
 			continue
 		}
-
-		// Convert timestamp lines
-		if strings.Contains(line, " --> ") {
-			// SRT: 00:00:20,000 --> 00:00:24,400
-			// VTT: 00:00:20.000 --> 00:00:24.400
-			line = strings.Replace(line, ",", ".", -1)
-			vttContent += line + "\n"
-		} else {
-			vttContent += line + "\n"
+		u, err := url.Parse(trackerURL)
+		if err != nil {
+			continue
+		}
+		if equivalent, ok := udpTrackerHTTPEquivalents[u.Host]; ok && !slices.Contains(trackers, equivalent) {
+			trackers = append(trackers, equivalent)
 		}
 	}
+	return trackers
+}
 
-	return []byte(vttContent)
+func isUDPTracker(trackerURL string) bool {
+	return strings.HasPrefix(strings.ToLower(trackerURL), "udp")
 }
 
-// Helper function to respond with JSON
-func respondWithJSON(w http.ResponseWriter, status int, data interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(data)
+// Whether the torrent has a name other than the placeholder anacrolix
+// derives from the infohash
+func hasDisplayName(t *torrent.Torrent) bool {
+	return !strings.HasPrefix(t.Name(), "infohash:")
 }
 
-// Update cleanupSessions with temp directory cleanup
-func cleanupSessions() {
-	ticker := time.NewTicker(2 * time.Minute) // Check more frequently
-	defer ticker.Stop()
+var errBlockedInfohash = errors.New("torrent is blocked on this server")
 
-	for range ticker.C {
-		cleaned := 0
-		sessions.Range(func(key, value interface{}) bool {
-			session := value.(*TorrentSession)
-
-			// Clean up sessions inactive for more than 10 minutes
-			if time.Since(session.LastUsed) > 10*time.Minute {
-				// Drop torrent first
-				session.Torrent.Drop()
-				// Close client
-				session.Client.Close()
-				// Release port
-				releasePort(session.Port)
-				// Remove temp directory
-				if session.TempDataDir != "" {
-					os.RemoveAll(session.TempDataDir)
-				}
-				// Remove from map
-				sessions.Delete(key)
-				cleaned++
-			}
-			return true
-		})
+// Reject magnets and download URLs longer than the configured limit, before
+// any parsing or network work is done on them
+func checkMagnetLength(magnet string) error {
+	settingsMutex.RLock()
+	maxLength := currentSettings.MaxMagnetLength
+	settingsMutex.RUnlock()
 
-		if cleaned > 0 {
-			// Force garbage collection to free memory
-			runtime.GC()
-		}
+	if len(magnet) > maxLength {
+		return fmt.Errorf("Magnet link or URL too long (max %d bytes)", maxLength)
 	}
+	return nil
 }
 
-// Test the proxy connection
-func testProwlarrConnection(w http.ResponseWriter, r *http.Request) {
-	// Add CORS headers
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-	// Handle preflight requests
-	if r.Method == "OPTIONS" {
+// Handler for GET /api/v1/stream?magnet=<magnet>&file=<idx>
+// Adds the magnet, or reuses the session already open for its infohash,
+// and streams the file in one request so the URL can be used directly as
+// a <video src>.
+func streamMagnetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	var settings ProwlarrSettings
-	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
-		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	magnet := r.URL.Query().Get("magnet")
+	if err := checkMagnetLength(magnet); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
 		return
 	}
-
-	prowlarrHost := settings.ProwlarrHost
-	prowlarrApiKey := settings.ProwlarrApiKey
-
-	if prowlarrHost == "" || prowlarrApiKey == "" {
-		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Prowlarr host or API key not set"})
+	if !strings.HasPrefix(magnet, "magnet:") {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid magnet link"})
 		return
 	}
-
-	client := createSelectiveProxyClient()
-	testURL := fmt.Sprintf("%s/api/v1/system/status", prowlarrHost)
-
-	req, err := http.NewRequest("GET", testURL, nil)
+	fileIndex, err := strconv.Atoi(r.URL.Query().Get("file"))
 	if err != nil {
-		log.Printf("Error creating request: %v", err)
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid file index"})
 		return
 	}
 
-	req.Header.Set("X-Api-Key", prowlarrApiKey)
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("Error making request to Prowlarr: %v", err)
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to connect to Prowlarr: " + err.Error()})
+	// Refuse blocked content and strip blocked trackers
+	magnet, err = applyBlocklists(magnet)
+	if errors.Is(err, errBlockedInfohash) {
+		respondWithJSON(w, http.StatusForbidden, map[string]string{"error": err.Error()})
 		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		respondWithJSON(w, resp.StatusCode, map[string]string{"error": fmt.Sprintf("Prowlarr returned status %d", resp.StatusCode)})
+	} else if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid magnet url"})
 		return
 	}
 
-	responseBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Error reading response: %v", err)
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to read Prowlarr response"})
+	session, sessionID, created, err := loadOrAddMagnetSession(magnet)
+	if errors.Is(err, errNoListenPort) {
+		requestLogger(r.Context()).Printf("Stream session error: %v", err)
+		respondWithJSON(w, http.StatusServiceUnavailable, map[string]string{"error": errNoListenPort.Error()})
 		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(responseBody)
-}
-
-// Search from Prowlarr
-func searchFromProwlarr(w http.ResponseWriter, r *http.Request) {
-	// Add CORS headers
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Prowlarr-Host, X-Api-Key")
-
-	// Handle preflight requests
-	if r.Method == "OPTIONS" {
+	} else if err != nil {
+		requestLogger(r.Context()).Printf("Stream session error: %v", err)
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to start torrent"})
 		return
 	}
-
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+	session.LastUsed = time.Now()
+	if created {
+		// Finishes the session, or tears it down on timeout, even if this
+		// request goes away first
+		go waitForMetadata(sessionID, session, nil)
 	}
 
-	query := r.URL.Query().Get("q")
-	if query == "" {
-		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "No search query provided"})
+	select {
+	case <-session.Torrent.GotInfo():
+	case <-r.Context().Done():
+		return
+	case <-time.After(metadataTimeout):
+		respondWithJSON(w, http.StatusGatewayTimeout, map[string]string{"error": "Timeout getting info - proxy might be blocking BitTorrent traffic"})
 		return
 	}
 
-	// search movies in prowlarr
-	settingsMutex.RLock()
-	prowlarrHost := currentSettings.ProwlarrHost
-	prowlarrApiKey := currentSettings.ProwlarrApiKey
-	settingsMutex.RUnlock()
-
-	if prowlarrHost == "" || prowlarrApiKey == "" {
-		http.Error(w, "Prowlarr host or API key not set", http.StatusBadRequest)
+	files := session.Torrent.Files()
+	if fileIndex < 0 || fileIndex >= len(files) {
+		http.Error(w, "File index out of range", http.StatusBadRequest)
 		return
 	}
 
-	// Use the client that bypasses proxy for Prowlarr
-	client := createSelectiveProxyClient()
-
-	// Prowlarr search endpoint - looking for movie torrents
-	searchURL := fmt.Sprintf("%s/api/v1/search?query=%s&limit=10", prowlarrHost, url.QueryEscape(query))
+	serveTorrentFile(w, r, sessionID, session, files[fileIndex])
+}
 
-	req, err := http.NewRequest("GET", searchURL, nil)
+// Return the open session for the magnet's infohash, or start a new one.
+// A new session is stored before its metadata arrives, flagged as loading,
+// so concurrent requests for the same magnet share it.
+func loadOrAddMagnetSession(magnet string) (*TorrentSession, string, bool, error) {
+	m, err := metainfo.ParseMagnetUri(magnet)
 	if err != nil {
-		log.Printf("Error creating request: %v", err)
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
-		return
+		return nil, "", false, err
+	}
+	sessionID := m.InfoHash.HexString()
+	if existing, ok := sessions.Get(sessionID); ok {
+		return existing, sessionID, false, nil
 	}
 
-	req.Header.Set("X-Api-Key", prowlarrApiKey)
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("Error making request to Prowlarr: %v", err)
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to connect to Prowlarr: " + err.Error()})
-		return
+	// UDP trackers fail silently behind the SOCKS5 proxy
+	magnet, trackerWarning := adaptTrackersForProxy(magnet)
+	if trackerWarning != "" {
+		log.Printf("Session %s: %s", sessionID, trackerWarning)
 	}
-	defer resp.Body.Close()
 
-	// Read the response body
-	body, err := io.ReadAll(resp.Body)
+	client, port, tempDir, err := initTorrentWithProxy()
 	if err != nil {
-		log.Printf("Error reading response: %v", err)
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to read Prowlarr response"})
-		return
+		return nil, "", false, err
+	}
+	t, err := client.AddMagnet(magnet)
+	if err != nil {
+		releasePort(port)
+		client.Close()
+		os.RemoveAll(tempDir)
+		return nil, "", false, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		respondWithJSON(w, resp.StatusCode, map[string]string{"error": fmt.Sprintf("Prowlarr returned status %d: %s", resp.StatusCode, string(body))})
-		return
+	session := &TorrentSession{
+		Client:      client,
+		Torrent:     t,
+		Port:        port,
+		LastUsed:    time.Now(),
+		TempDataDir: tempDir, // Store temp dir for cleanup
+		CreatedAt:   time.Now(),
+		SeedGrace:   seedGracePeriod(),
 	}
+	session.metadataLoading.Store(true)
 
-	// Parse the JSON response and process the results
-	var results []map[string]interface{}
-	if err := json.Unmarshal(body, &results); err != nil {
-		log.Printf("Error parsing JSON: %v", err)
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to parse Prowlarr response"})
-		return
+	// Another request may have opened the same torrent in the meantime
+	if existing, loaded := sessions.LoadOrStore(sessionID, session); loaded {
+		releasePort(port)
+		client.Close()
+		os.RemoveAll(tempDir)
+		return existing, sessionID, false, nil
 	}
+	return session, sessionID, true, nil
+}
 
-	// Process the results to make them more usable by the frontend
-	var processedResults []map[string]interface{}
-	for _, result := range results {
-		// Get title and download URL
-		title, hasTitle := result["title"].(string)
-		downloadUrl, hasDownloadUrl := result["downloadUrl"].(string)
+// Async adds whose metadata never arrived, kept so /status can report
+// the failure after the session itself has been torn down
+var failedSessions sync.Map // sessionID -> failedSession
 
-		// Magnet URL might be present in some results
-		magnetUrl, hasMagnet := result["magnetUrl"].(string)
+type failedSession struct {
+	Reason   string
+	FailedAt time.Time
+}
 
-		if !hasTitle || title == "" {
-			// Skip results without titles
-			continue
+// Finish an async add once the torrent info arrives, or tear the session
+// down if it doesn't arrive in time
+func waitForMetadata(sessionID string, session *TorrentSession, files []int) {
+	fail := func(reason string) {
+		log.Printf("Async add of %s failed: %s", sessionID, reason)
+		// The session may already be gone if the client deleted it, or
+		// replaced by a later add of the same torrent
+		if current, ok := sessions.Get(sessionID); ok && current == session {
+			closeSession(sessionID, session)
+			failedSessions.Store(sessionID, failedSession{Reason: reason, FailedAt: time.Now()})
 		}
+	}
 
-		// We need at least one of download URL or magnet URL
-		if (!hasDownloadUrl || downloadUrl == "") && (!hasMagnet || magnetUrl == "") {
-			continue
-		}
+	select {
+	case <-session.Torrent.GotInfo():
+	case <-session.Torrent.Closed():
+		return
+	case <-time.After(metadataTimeout):
+		fail("Timeout getting info - proxy might be blocking BitTorrent traffic")
+		return
+	}
 
-		// Create a simplified result object with just what we need
-		processedResult := map[string]interface{}{
-			"title": title,
+	// Only fetch the files the user asked for
+	if len(files) > 0 {
+		if err := selectFiles(session.Torrent, files); err != nil {
+			fail(err.Error())
+			return
 		}
+	}
 
-		// Prefer magnet URLs if available directly
-		if hasMagnet && magnetUrl != "" {
-			processedResult["magnetUrl"] = magnetUrl
-			processedResult["directMagnet"] = true
-		} else if hasDownloadUrl && downloadUrl != "" {
-			processedResult["downloadUrl"] = downloadUrl
-			processedResult["directMagnet"] = false
-		}
+	session.metadataLoading.Store(false)
+	log.Printf("Metadata ready for session %s", sessionID)
+}
 
-		// Include optional fields if they exist
-		if size, ok := result["size"].(float64); ok {
-			processedResult["size"] = formatSize(size)
+// Handler for GET /api/v1/torrent/{sessionId}/status
+func sessionStatusHandler(w http.ResponseWriter, r *http.Request, session *TorrentSession) {
+	if session.metadataLoading.Load() {
+		respondWithJSON(w, http.StatusOK, map[string]interface{}{
+			"metadata": "loading",
+			"name":     session.Torrent.Name(),
+		})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"metadata": "ready",
+		"name":     session.Torrent.Name(),
+		"files":    len(session.Torrent.Files()),
+	})
+}
+
+// Reject magnets for blocked infohashes and drop blocked trackers from the rest
+func applyBlocklists(magnet string) (string, error) {
+	m, err := metainfo.ParseMagnetUri(magnet)
+	if err != nil {
+		return "", err
+	}
+
+	if err := checkInfohashAllowed(m.InfoHash.HexString()); err != nil {
+		return "", err
+	}
+
+	// Leave the magnet untouched unless a tracker actually has to go
+	trackers := filterBlockedTrackers(m.Trackers)
+	if len(trackers) == len(m.Trackers) {
+		return magnet, nil
+	}
+
+	log.Printf("Stripped %d blocked trackers from magnet", len(m.Trackers)-len(trackers))
+	m.Trackers = trackers
+	return m.String(), nil
+}
+
+// Same as applyBlocklists, for a torrent added from a .torrent file
+func applyBlocklistsToMetaInfo(mi *metainfo.MetaInfo) error {
+	if err := checkInfohashAllowed(mi.HashInfoBytes().HexString()); err != nil {
+		return err
+	}
+
+	if mi.Announce != "" && len(filterBlockedTrackers([]string{mi.Announce})) == 0 {
+		mi.Announce = ""
+	}
+	var announceList metainfo.AnnounceList
+	for _, tier := range mi.AnnounceList {
+		if tier = filterBlockedTrackers(tier); len(tier) > 0 {
+			announceList = append(announceList, tier)
 		}
+	}
+	mi.AnnounceList = announceList
+	return nil
+}
 
-		if seeders, ok := result["seeders"].(float64); ok {
-			processedResult["seeders"] = seeders
+func checkInfohashAllowed(infoHash string) error {
+	settingsMutex.RLock()
+	blockedInfohashes := currentSettings.BlockedInfohashes
+	settingsMutex.RUnlock()
+
+	for _, blocked := range blockedInfohashes {
+		if strings.EqualFold(strings.TrimSpace(blocked), infoHash) {
+			log.Printf("Refusing blocked infohash %s", infoHash)
+			return errBlockedInfohash
 		}
+	}
+	return nil
+}
 
-		if leechers, ok := result["leechers"].(float64); ok {
-			processedResult["leechers"] = leechers
+func filterBlockedTrackers(trackers []string) []string {
+	settingsMutex.RLock()
+	blockedTrackers := currentSettings.BlockedTrackers
+	settingsMutex.RUnlock()
+
+	var allowed []string
+	for _, tracker := range trackers {
+		if !isTrackerBlocked(tracker, blockedTrackers) {
+			allowed = append(allowed, tracker)
 		}
+	}
+	return allowed
+}
 
-		if indexer, ok := result["indexer"].(string); ok {
-			processedResult["indexer"] = indexer
+func isTrackerBlocked(tracker string, blockedTrackers []string) bool {
+	tracker = strings.ToLower(tracker)
+	for _, blocked := range blockedTrackers {
+		blocked = strings.ToLower(strings.TrimSpace(blocked))
+		if blocked != "" && strings.Contains(tracker, blocked) {
+			return true
 		}
+	}
+	return false
+}
 
-		if publishDate, ok := result["publishDate"].(string); ok {
-			processedResult["publishDate"] = publishDate
+// Follow a Prowlarr/Jackett download link. Returns the magnet it redirects to,
+// or the parsed metainfo when the link serves a .torrent file directly.
+func resolveMagnetFromURL(downloadURL string) (string, *metainfo.MetaInfo, error) {
+	// Copy the client so the redirect policy doesn't leak into the shared one
+	httpClient := *createSelectiveProxyClient()
+
+	// Follow http redirects but stop at the magnet link itself
+	httpClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return http.ErrUseLastResponse
 		}
+		if len(via) >= 10 {
+			return errors.New("too many redirects")
+		}
+		return nil
+	}
 
-		if category, ok := result["category"].(string); ok {
-			processedResult["category"] = category
+	// Make the HTTP request to follow the Prowlarr link
+	req, err := http.NewRequest("GET", downloadURL, nil)
+	if err != nil {
+		log.Printf("Error creating request: %v", err)
+		return "", nil, fmt.Errorf("Invalid URL: %v", err)
+	}
+
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
+
+	// Follow the Prowlarr link
+	log.Printf("Following Prowlarr URL: %s", downloadURL)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		log.Printf("Error following URL: %v", err)
+		return "", nil, fmt.Errorf("Failed to download: %v", err)
+	}
+	defer resp.Body.Close()
+
+	log.Printf("Got response: %d %s", resp.StatusCode, resp.Status)
+
+	// Check for redirects to magnet links
+	if resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		location := resp.Header.Get("Location")
+		log.Printf("Found redirect to: %s", location)
+
+		if !strings.HasPrefix(location, "magnet:") {
+			log.Printf("Non-magnet redirect: %s", location)
+			return "", nil, errors.New("URL redirects to non-magnet content")
 		}
+		log.Printf("Found magnet redirect: %s", location)
+		return location, nil, nil
+	}
 
-		processedResults = append(processedResults, processedResult)
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("Download returned status %d", resp.StatusCode)
+	}
+
+	// Some indexers serve the .torrent itself instead of redirecting
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxTorrentFileSize))
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to download: %v", err)
+	}
+	if !isTorrentResponse(resp.Header.Get("Content-Type"), body) {
+		return "", nil, errors.New("URL did not return a magnet link or torrent file")
 	}
 
-	respondWithJSON(w, http.StatusOK, processedResults)
+	mi, err := loadMetaInfoLenient(body)
+	if err != nil {
+		return "", nil, fmt.Errorf("Invalid torrent file: %v", err)
+	}
+	return "", mi, nil
 }
 
-// Test Jackett Connection Handler
-func testJackettConnection(w http.ResponseWriter, r *http.Request) {
-	// Add CORS headers
+// Whether a response looks like a .torrent file: the right content type
+// or a bencoded dictionary body
+func isTorrentResponse(contentType string, body []byte) bool {
+	if strings.HasPrefix(contentType, "application/x-bittorrent") {
+		return true
+	}
+	return len(body) > 0 && body[0] == 'd' && bytes.Contains(body, []byte("4:info"))
+}
+
+// Mark the wanted files for download and set every other file's priority to none
+func selectFiles(t *torrent.Torrent, wanted []int) error {
+	files := t.Files()
+	wantedSet := make(map[int]bool, len(wanted))
+	for _, index := range wanted {
+		if index < 0 || index >= len(files) {
+			return fmt.Errorf("file index %d out of range", index)
+		}
+		wantedSet[index] = true
+	}
+
+	for i, file := range files {
+		if wantedSet[i] {
+			file.Download()
+		} else {
+			file.SetPriority(torrent.PiecePriorityNone)
+		}
+	}
+	return nil
+}
+
+// Handler for POST /api/v1/torrent/resolve
+// Turns an indexer download link into a magnet without adding it
+func resolveTorrentURLHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-	// Handle preflight requests
 	if r.Method == "OPTIONS" {
 		return
 	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
-	var settings JackettSettings
-	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+	var request struct {
+		URL string `json:"url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
 		return
 	}
-
-	jackettHost := settings.JackettHost
-	jackettApiKey := settings.JackettApiKey
-
-	if jackettHost == "" || jackettApiKey == "" {
-		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Jackett host or API key not set"})
+	if err := checkMagnetLength(request.URL); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if !strings.HasPrefix(request.URL, "http") {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "URL must be http or https"})
 		return
 	}
 
-	client := createSelectiveProxyClient()
-	testURL := fmt.Sprintf("%s/api/v2.0/indexers/all/results?apikey=%s", jackettHost, jackettApiKey)
-	req, err := http.NewRequest("GET", testURL, nil)
+	magnet, mi, err := resolveMagnetFromURL(request.URL)
 	if err != nil {
-		log.Printf("Error creating request: %v", err)
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		respondWithJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
 		return
 	}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("Error making request to Jackett: %v", err)
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to connect to Jackett: " + err.Error()})
+
+	source := "redirect"
+	if mi != nil {
+		magnet = magnetFromMetaInfo(mi, defaultMaxMagnetTrackers)
+		source = "torrent"
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]string{
+		"magnet": magnet,
+		"source": source,
+	})
+}
+
+// Result of parsing one magnet; exactly one of InfoHash and Error is set
+type InfohashResult struct {
+	Magnet   string `json:"magnet"`
+	InfoHash string `json:"infoHash,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Handler for /api/v1/torrent/infohash. Parses magnets into infohashes
+// without adding them, so clients can dedupe results across sources.
+func infohashHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	if r.Method == "OPTIONS" {
 		return
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		respondWithJSON(w, resp.StatusCode, map[string]string{"error": fmt.Sprintf("Jackett returned status %d", resp.StatusCode)})
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	responseBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Error reading response: %v", err)
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to read Jackett response"})
+
+	var request struct {
+		Magnets []string `json:"magnets"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(responseBody)
+	if len(request.Magnets) > maxInfohashBatch {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{
+			"error": fmt.Sprintf("At most %d magnets per request", maxInfohashBatch),
+		})
+		return
+	}
+
+	results := make([]InfohashResult, len(request.Magnets))
+	for i, magnet := range request.Magnets {
+		results[i].Magnet = magnet
+		if err := checkMagnetLength(magnet); err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		m, err := metainfo.ParseMagnetUri(magnet)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].InfoHash = m.InfoHash.HexString()
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"results": results,
+	})
 }
 
-// Search from Jackett
-func searchFromJackett(w http.ResponseWriter, r *http.Request) {
-	// Add CORS headers
+// Swarm size of a magnet as its trackers report it
+type TorrentHealth struct {
+	Score             int `json:"score"` // 0-100, see healthScore
+	Seeders           int `json:"seeders"`
+	Leechers          int `json:"leechers"`
+	Completed         int `json:"completed"`
+	TrackersScraped   int `json:"trackersScraped"`
+	TrackersResponded int `json:"trackersResponded"`
+
+	SkippedTrackers []string `json:"skippedTrackers,omitempty"` // UDP trackers left out because they can't go through the proxy
+}
+
+// Handler for POST /api/v1/torrent/health. Scrapes the magnet's trackers,
+// or the default ones when it has none, without adding the torrent.
+func torrentHealthHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-	// Handle preflight requests
 	if r.Method == "OPTIONS" {
 		return
 	}
-
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	query := r.URL.Query().Get("q")
-	if query == "" {
-		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "No search query provided"})
+	var request struct {
+		Magnet string `json:"magnet"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+	if err := checkMagnetLength(request.Magnet); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	m, err := metainfo.ParseMagnetUri(request.Magnet)
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid magnet url"})
 		return
 	}
 
-	// search movies in jackett
+	trackers := filterBlockedTrackers(m.Trackers)
+	if len(trackers) == 0 {
+		trackers = filterBlockedTrackers(defaultTrackers())
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), healthScrapeTimeout)
+	defer cancel()
+	health := scrapeTrackers(ctx, m.InfoHash, trackers)
+	health.Score = healthScore(health.Seeders, health.Leechers)
+
+	respondWithJSON(w, http.StatusOK, health)
+}
+
+// Scrape every tracker at once and keep the largest counts any of them
+// reports, since trackers only see their own part of the swarm. With the
+// proxy on, UDP trackers are skipped rather than contacted directly.
+func scrapeTrackers(ctx context.Context, infoHash metainfo.Hash, trackers []string) TorrentHealth {
 	settingsMutex.RLock()
-	jackettHost := currentSettings.JackettHost
-	jackettApiKey := currentSettings.JackettApiKey
+	chain := proxyChain()
 	settingsMutex.RUnlock()
 
-	if jackettHost == "" || jackettApiKey == "" {
-		http.Error(w, "Jackett host or API key not set", http.StatusBadRequest)
-		return
+	var health TorrentHealth
+	var opts tracker.NewClientOpts
+	if len(chain) > 0 {
+		for _, trackerURL := range trackers {
+			if isUDPTracker(trackerURL) {
+				health.SkippedTrackers = append(health.SkippedTrackers, trackerURL)
+			}
+		}
+		trackers = slices.DeleteFunc(slices.Clone(trackers), isUDPTracker)
+
+		proxyDialer, err := createProxyDialer(chain...)
+		if err != nil {
+			log.Printf("Scrape skipped, no proxy dialer: %v", err)
+			return health
+		}
+		opts.Http.DialContext = proxyDialContext(proxyDialer)
 	}
 
-	// Use the client that bypasses proxy for Jackett
-	client := createSelectiveProxyClient()
+	if len(trackers) > maxHealthTrackers {
+		trackers = trackers[:maxHealthTrackers]
+	}
+	health.TrackersScraped = len(trackers)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, trackerURL := range trackers {
+		wg.Add(1)
+		go func(trackerURL string) {
+			defer wg.Done()
+			client, err := tracker.NewClient(trackerURL, opts)
+			if err != nil {
+				return
+			}
+			defer client.Close()
+			res, err := client.Scrape(ctx, []metainfo.Hash{infoHash})
+			if err != nil || len(res) == 0 {
+				log.Printf("Scrape of %s failed: %v", trackerURL, err)
+				return
+			}
 
-	// Jackett search endpoint - looking for movie torrents
-	searchURL := fmt.Sprintf("%s/api/v2.0/indexers/all/results?Query=%s&apikey=%s", jackettHost, url.QueryEscape(query), jackettApiKey)
+			mu.Lock()
+			defer mu.Unlock()
+			health.TrackersResponded++
+			health.Seeders = max(health.Seeders, int(res[0].Seeders))
+			health.Leechers = max(health.Leechers, int(res[0].Leechers))
+			health.Completed = max(health.Completed, int(res[0].Completed))
+		}(trackerURL)
+	}
+	wg.Wait()
+	return health
+}
+
+// Health score from 0 to 100. Up to 80 points come from the seeder count
+// on a log scale, reaching 80 at healthFullSeeders, so the first few
+// seeders matter most. The other 20 are the share of the swarm that are
+// seeders, i.e. how available the full data is to each leecher.
+// A torrent without seeders scores 0.
+func healthScore(seeders, leechers int) int {
+	if seeders <= 0 {
+		return 0
+	}
+	seederPart := 80 * min(1, math.Log1p(float64(seeders))/math.Log1p(healthFullSeeders))
+	availabilityPart := 20 * float64(seeders) / float64(seeders+leechers)
+	return int(math.Round(seederPart + availabilityPart))
+}
+
+// Torrent handler to serve torrent files and stream content
+func torrentHandler(w http.ResponseWriter, r *http.Request) {
+	// Extract sessionId and possibly fileIndex from the URL
+	parts := strings.Split(r.URL.Path, "/")
+
+	// The URL structure is /api/v1/torrent/[sessionId]/...
+	if len(parts) < 5 {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid path"})
+		return
+	}
+
+	// The session ID is at position 4
+	sessionID := parts[4]
+
+	// Get the torrent session from our sessions map
+	session, ok := sessions.Get(sessionID)
+	if !ok && len(parts) > 5 && parts[5] == "status" {
+		if failed, ok := failedSessions.Load(sessionID); ok {
+			respondWithJSON(w, http.StatusOK, map[string]interface{}{
+				"metadata": "failed",
+				"error":    failed.(failedSession).Reason,
+			})
+			return
+		}
+	}
+	if !ok {
+		respondWithJSON(w, http.StatusNotFound, map[string]string{
+			"error": "Session not found",
+			"id":    sessionID,
+		})
+		return
+	}
+	session.LastUsed = time.Now() // Update last used time
+
+	// DELETE /api/v1/torrent/{sessionId} closes the session and reports its usage
+	if len(parts) == 5 && r.Method == http.MethodDelete {
+		bytesRead := closeSession(sessionID, session)
+		respondWithJSON(w, http.StatusOK, map[string]interface{}{
+			"message":         "Session closed",
+			"bytesDownloaded": bytesRead,
+			"downloaded":      formatSize(float64(bytesRead)),
+		})
+		return
+	}
+
+	// Report whether the metadata of an async add has arrived
+	if len(parts) > 5 && parts[5] == "status" {
+		sessionStatusHandler(w, r, session)
+		return
+	}
+
+	// Everything below needs the torrent info
+	if session.metadataLoading.Load() {
+		respondWithJSON(w, http.StatusConflict, map[string]string{"error": "Metadata still loading"})
+		return
+	}
+
+	// Report piece completion as a compact bitfield
+	if len(parts) > 5 && parts[5] == "pieces" {
+		piecesHandler(w, r, session)
+		return
+	}
+
+	// Server-sent progress events, including stall errors
+	if len(parts) > 5 && parts[5] == "events" {
+		sessionEventsHandler(w, r, session)
+		return
+	}
+
+	// Force the downloaded data to be re-verified
+	if len(parts) > 5 && parts[5] == "recheck" {
+		recheckHandler(w, r, session)
+		return
+	}
+
+	// Download the .torrent file for the session
+	if len(parts) > 5 && parts[5] == "metainfo" {
+		metainfoHandler(w, r, session)
+		return
+	}
+
+	// Report download statistics for the session
+	if len(parts) > 5 && parts[5] == "stats" {
+		sessionStatsHandler(w, r, session)
+		return
+	}
+
+	// Estimate the time left until the download completes
+	if len(parts) > 5 && parts[5] == "eta" {
+		etaHandler(w, r, session)
+		return
+	}
+
+	// Cut a time range out of a video as a standalone MP4
+	if len(parts) > 5 && parts[5] == "clip" {
+		clipHandler(w, r, sessionID, session)
+		return
+	}
+
+	// Ask trackers and the DHT for more peers right away
+	if len(parts) > 5 && parts[5] == "reannounce" {
+		reannounceHandler(w, r, session)
+		return
+	}
+
+	// Pick the main video and say whether a browser can play it as is
+	if len(parts) > 5 && parts[5] == "playable" {
+		playableHandler(w, r, sessionID, session)
+		return
+	}
+
+	// List the peers the session is connected to
+	if len(parts) > 5 && parts[5] == "peers" {
+		peersHandler(w, r, session)
+		return
+	}
+
+	// List the container's video, audio and subtitle tracks
+	if len(parts) > 5 && parts[5] == "mediainfo" {
+		mediaInfoHandler(w, r, sessionID, session)
+		return
+	}
+
+	// Report whether the start of a file is buffered enough to begin playback
+	if len(parts) > 5 && parts[5] == "ready" {
+		fileReadyHandler(w, r, session)
+		return
+	}
+
+	// Stream a file matched by its display path instead of its index
+	if len(parts) > 5 && parts[5] == "stream-by-name" {
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			http.Error(w, "Missing file name", http.StatusBadRequest)
+			return
+		}
+
+		file := findFileByName(session.Torrent, name)
+		if file == nil {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
+
+		serveTorrentFile(w, r, sessionID, session, file)
+		return
+	}
+
+	// If there's a streaming request, handle it
+	if len(parts) > 5 && parts[5] == "stream" { // Changed from parts[4] to parts[5]
+		if len(parts) < 7 { // Changed from 6 to 7
+			http.Error(w, "Invalid stream path", http.StatusBadRequest)
+			return
+		}
+
+		fileIndexString := parts[6]
+		// remove .vtt from fileIndex if it exists
+		fileIndexString = strings.TrimSuffix(fileIndexString, ".vtt")
+
+		fileIndex, err := strconv.Atoi(fileIndexString)
+
+		if err != nil {
+			http.Error(w, "Invalid file index", http.StatusBadRequest)
+			return
+		}
+
+		if fileIndex < 0 || fileIndex >= len(session.Torrent.Files()) {
+			http.Error(w, "File index out of range", http.StatusBadRequest)
+			return
+		}
+
+		file := session.Torrent.Files()[fileIndex]
+
+		serveTorrentFile(w, r, sessionID, session, file)
+		return
+	}
+
+	// If we get here, just return file list
+	var files []map[string]interface{}
+	for i, file := range session.Torrent.Files() {
+		files = append(files, map[string]interface{}{
+			"index": i,
+			"name":  file.DisplayPath(),
+			"size":  file.Length(),
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, files)
+}
+
+// Find a file by its display path, ignoring slash direction
+func findFileByName(t *torrent.Torrent, name string) *torrent.File {
+	name = filepath.ToSlash(name)
+	for _, file := range t.Files() {
+		if filepath.ToSlash(file.DisplayPath()) == name {
+			return file
+		}
+	}
+	return nil
+}
+
+// Content-Type served for each known file extension; anything else is
+// sent as application/octet-stream
+var contentTypeFor = map[string]string{
+	".mp4":  "video/mp4",
+	".webm": "video/webm",
+	".mkv":  "video/x-matroska",
+	".avi":  "video/x-msvideo",
+	".ts":   "video/mp2t",
+	".m2ts": "video/mp2t",
+	".m3u8": "application/vnd.apple.mpegurl",
+	".srt":  "text/plain",
+	".vtt":  "text/vtt",
+	".sub":  "text/plain",
+}
+
+// Lowercase extensions with a leading dot, without blanks or duplicates
+func normalizeExtensions(extensions []string) []string {
+	var normalized []string
+	for _, extension := range extensions {
+		extension = strings.ToLower(strings.TrimSpace(extension))
+		if extension == "" {
+			continue
+		}
+		if !strings.HasPrefix(extension, ".") {
+			extension = "." + extension
+		}
+		if !slices.Contains(normalized, extension) {
+			normalized = append(normalized, extension)
+		}
+	}
+	return normalized
+}
+
+// Whether the operator allows streaming files with this name
+func streamExtensionAllowed(fileName string) bool {
+	settingsMutex.RLock()
+	allowed := currentSettings.AllowedStreamExtensions
+	settingsMutex.RUnlock()
+
+	return len(allowed) == 0 || slices.Contains(allowed, strings.ToLower(filepath.Ext(fileName)))
+}
+
+// Extensions the stream handler serves as video or audio, sorted
+func streamableExtensions() []string {
+	var extensions []string
+	for extension, contentType := range contentTypeFor {
+		if !streamExtensionAllowed(extension) {
+			continue
+		}
+		if strings.HasPrefix(contentType, "video/") || strings.HasPrefix(contentType, "audio/") {
+			extensions = append(extensions, extension)
+		}
+	}
+	sort.Strings(extensions)
+	return extensions
+}
+
+// Handler for GET /api/v1/streamable-extensions, so the frontend knows
+// which files can play without guessing
+func streamableExtensionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"extensions": streamableExtensions(),
+	})
+}
+
+// Stream a single file from the torrent with the right Content-Type,
+// converting SRT subtitles to VTT when requested
+func serveTorrentFile(w http.ResponseWriter, r *http.Request, sessionID string, session *TorrentSession, file *torrent.File) {
+	// Optional number of MB to download before the response starts, or
+	// "auto" for the configured prebuffer seconds at the file's bitrate
+	var prebufferBytes int64
+	if param := r.URL.Query().Get("prebuffer"); param == "auto" {
+		settingsMutex.RLock()
+		prebufferSeconds := currentSettings.PrebufferSeconds
+		settingsMutex.RUnlock()
+		bitrate := streamBitrate(session, file)
+		prebufferBytes = prebufferBytesFor(file, bitrate, prebufferSeconds)
+	} else if param != "" {
+		megabytes, err := strconv.Atoi(param)
+		if err != nil || megabytes < 0 {
+			http.Error(w, "Invalid prebuffer value", http.StatusBadRequest)
+			return
+		}
+		prebufferBytes = min(int64(megabytes)<<20, file.Length())
+	}
+
+	// Let cross-origin players read the headers they need for seeking
+	settingsMutex.RLock()
+	corsOrigin := currentSettings.StreamCORSOrigin
+	settingsMutex.RUnlock()
+	w.Header().Set("Access-Control-Allow-Origin", corsOrigin)
+	w.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Range, Accept-Ranges, ETag")
+	// HLS players fetch playlists and segments cross-origin with Range
+	// requests, which need a preflight
+	w.Header().Set("Access-Control-Allow-Headers", "Range")
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	// Set appropriate Content-Type based on file extension
+	fileName := file.DisplayPath()
+	extension := strings.ToLower(filepath.Ext(fileName))
+
+	// Refuse file types the operator hasn't allowed
+	if !streamExtensionAllowed(fileName) {
+		http.Error(w, "Streaming this file type is not allowed", http.StatusForbidden)
+		return
+	}
+
+	// For SRT, convert to VTT on-the-fly if requested as VTT
+	if extension == ".srt" && r.URL.Query().Get("format") == "vtt" {
+		w.Header().Set("Content-Type", "text/vtt")
+
+		// Read the SRT file with size limit
+		reader := file.NewReader()
+		defer reader.Close()
+		// Wrap with limiting reader to prevent memory issues (10MB max)
+		limitReader := io.LimitReader(reader, 10*1024*1024) // 10MB limit for subtitles
+		srtBytes, err := io.ReadAll(limitReader)
+		if err != nil {
+			http.Error(w, "Failed to read subtitle file", http.StatusInternalServerError)
+			return
+		}
+
+		// Convert from SRT to VTT
+		vttBytes := convertSRTtoVTT(srtBytes, r.URL.Query().Get("keepIds") == "1")
+		w.Write(vttBytes)
+		return
+	}
+
+	// Transcode down for slow clients when asked, if ffmpeg is installed
+	profile, transcode, err := parseTranscodeProfile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if transcode && strings.HasPrefix(contentTypeFor[extension], "video/") {
+		if ffmpegPath, err := exec.LookPath("ffmpeg"); err == nil {
+			session.streamStarted()
+			defer session.streamFinished()
+			serveTranscoded(w, r, ffmpegPath, sessionID, session, file, profile)
+			return
+		}
+		requestLogger(r.Context()).Printf("ffmpeg not found, streaming %s without transcoding", fileName)
+	}
+
+	if contentType, ok := contentTypeFor[extension]; ok {
+		w.Header().Set("Content-Type", contentType)
+	} else {
+		// Files without a known extension may still be playable
+		w.Header().Set("Content-Type", sniffContentType(r.Context(), file))
+	}
+
+	// A torrent's content never changes, so a strong validator lets
+	// browsers resume interrupted downloads with If-Range
+	w.Header().Set("ETag", fileETag(session.Torrent, file))
+	if r.URL.Query().Get("download") == "1" {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+			"filename": filepath.Base(fileName),
+		}))
+	}
+
+	// Add CORS headers for all content
+	// Stream the file
+	session.streamStarted()
+	defer session.streamFinished()
+	// Only the initial request waits; seeks elsewhere in the file shouldn't
+	if rangeHeader := r.Header.Get("Range"); prebufferBytes > 0 && (rangeHeader == "" || strings.HasPrefix(rangeHeader, "bytes=0-")) {
+		waitForPrebuffer(r.Context(), session.Torrent, file, prebufferBytes)
+	}
+	reader := file.NewReader()
+	// ServeContent doesn't close the reader, so make sure it is
+	// released however the request ends
+	defer reader.Close()
+
+	// Follow the download rate with the readahead while the stream runs,
+	// and stop before the reader is closed
+	adaptCtx, stopAdapting := context.WithCancel(r.Context())
+	adaptDone := make(chan struct{})
+	go func() {
+		defer close(adaptDone)
+		adaptReadahead(adaptCtx, session, reader)
+	}()
+	defer func() {
+		stopAdapting()
+		<-adaptDone
+	}()
+
+	http.ServeContent(newFlushWriter(w), r, fileName, time.Time{}, reader)
+}
+
+// Response writer that flushes after every write, so players and reverse
+// proxies get stream data as soon as it is read from the torrent rather
+// than whenever net/http's buffer fills
+type flushWriter struct {
+	http.ResponseWriter
+	flusher http.Flusher
+}
+
+// Wrap w in a flushWriter, or return it as is if it can't flush
+func newFlushWriter(w http.ResponseWriter) http.ResponseWriter {
+	if flusher, ok := w.(http.Flusher); ok {
+		return &flushWriter{ResponseWriter: w, flusher: flusher}
+	}
+	return w
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.ResponseWriter.Write(p)
+	if n > 0 {
+		fw.flusher.Flush()
+	}
+	return n, err
+}
+
+// Lets http.ResponseController reach the underlying writer
+func (fw *flushWriter) Unwrap() http.ResponseWriter {
+	return fw.ResponseWriter
+}
+
+// Strong ETag for a file in a torrent. The infohash pins the content and the
+// offset tells the torrent's files apart.
+func fileETag(t *torrent.Torrent, file *torrent.File) string {
+	return fmt.Sprintf(`"%s-%d"`, t.InfoHash().HexString(), file.Offset())
+}
+
+// Keep a stream's readahead at about readaheadSeconds of download, so fast
+// swarms buffer further ahead and slow ones don't request more than they
+// can fetch. The rate is read here rather than in a ReadaheadFunc because
+// anacrolix calls those with its client lock held.
+func adaptReadahead(ctx context.Context, session *TorrentSession, reader torrent.Reader) {
+	ticker := time.NewTicker(readaheadUpdateInterval)
+	defer ticker.Stop()
+
+	for {
+		settingsMutex.RLock()
+		minBytes := int64(currentSettings.MinReadaheadMB) << 20
+		maxBytes := int64(currentSettings.MaxReadaheadMB) << 20
+		settingsMutex.RUnlock()
+
+		reader.SetReadahead(readaheadForRate(session.DownloadRate(), minBytes, maxBytes))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Readahead covering readaheadSeconds at the given rate, within bounds
+func readaheadForRate(bytesPerSecond float64, minBytes, maxBytes int64) int64 {
+	return max(minBytes, min(int64(bytesPerSecond*readaheadSeconds), maxBytes))
+}
+
+// Output size and video bitrate requested with ?transcode= or ?maxbitrate=
+type transcodeProfile struct {
+	Height      int // 0 keeps the source resolution
+	BitrateKbps int
+}
+
+// Named ?transcode= presets
+var transcodePresets = map[string]transcodeProfile{
+	"480p":  {Height: 480, BitrateKbps: 1000},
+	"720p":  {Height: 720, BitrateKbps: 2500},
+	"1080p": {Height: 1080, BitrateKbps: 5000},
+}
+
+// Read ?transcode=<preset> and/or ?maxbitrate=<kbps>. ok is false when
+// neither is set; maxbitrate overrides the preset's bitrate.
+func parseTranscodeProfile(r *http.Request) (transcodeProfile, bool, error) {
+	preset := r.URL.Query().Get("transcode")
+	maxBitrate := r.URL.Query().Get("maxbitrate")
+	if preset == "" && maxBitrate == "" {
+		return transcodeProfile{}, false, nil
+	}
+
+	profile := transcodeProfile{BitrateKbps: transcodeDefaultBitrateKbps}
+	if preset != "" {
+		var ok bool
+		if profile, ok = transcodePresets[strings.ToLower(preset)]; !ok {
+			return transcodeProfile{}, false, errors.New("Invalid transcode value")
+		}
+	}
+	if maxBitrate != "" {
+		kbps, err := strconv.Atoi(maxBitrate)
+		if err != nil || kbps < minTranscodeBitrateKbps || kbps > maxTranscodeBitrateKbps {
+			return transcodeProfile{}, false, errors.New("Invalid maxbitrate value")
+		}
+		profile.BitrateKbps = kbps
+	}
+	return profile, true, nil
+}
+
+// Run the file through ffmpeg and stream fragmented MP4 back. ffmpeg reads
+// the session's stream endpoint, like clips and mediainfo do, so it can
+// seek to the index of containers that keep it at the end. Its output pipe
+// gives natural backpressure: ffmpeg only reads from the torrent as fast as
+// the client takes the output, and it is killed when the client goes away.
+// Transcoded output has no known length, so Range requests aren't supported.
+func serveTranscoded(w http.ResponseWriter, r *http.Request, ffmpegPath, sessionID string, session *TorrentSession, file *torrent.File, profile transcodeProfile) {
+	fileIndex := slices.Index(session.Torrent.Files(), file)
+	input := fmt.Sprintf("%s/api/v1/torrent/%s/stream/%d", localBaseURL, url.PathEscape(sessionID), fileIndex)
+	args := []string{"-hide_banner", "-loglevel", "error", "-i", input}
+	if profile.Height > 0 {
+		// -2 keeps the aspect ratio with an even width, as libx264 requires
+		args = append(args, "-vf", fmt.Sprintf("scale=-2:'min(%d,ih)'", profile.Height))
+	}
+	args = append(args,
+		"-c:v", "libx264", "-preset", "veryfast",
+		"-b:v", fmt.Sprintf("%dk", profile.BitrateKbps),
+		"-maxrate", fmt.Sprintf("%dk", profile.BitrateKbps),
+		"-bufsize", fmt.Sprintf("%dk", 2*profile.BitrateKbps),
+		"-c:a", "aac", "-b:a", "128k",
+		"-movflags", "frag_keyframe+empty_moov+default_base_moof",
+		"-f", "mp4", "pipe:1",
+	)
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(r.Context(), ffmpegPath, args...)
+	cmd.Stdout = newFlushWriter(w)
+	cmd.Stderr = &stderr
+
+	w.Header().Set("Content-Type", "video/mp4")
+	w.Header().Set("Accept-Ranges", "none")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	requestLogger(r.Context()).Printf("Transcoding %s at %dkbps", file.DisplayPath(), profile.BitrateKbps)
+	if err := cmd.Run(); err != nil && r.Context().Err() == nil {
+		requestLogger(r.Context()).Printf("Transcoding %s failed: %v: %s", file.DisplayPath(), err, strings.TrimSpace(stderr.String()))
+	}
+}
+
+// Base URL the server can reach itself on, set once it starts listening
+var localBaseURL string
+
+// Address the HTTP server listens on, set in main
+var serverAddr string
+
+// A generated clip on disk
+type clipCacheEntry struct {
+	path      string
+	createdAt time.Time
+}
+
+var (
+	clipCacheMu sync.Mutex
+	clipCache   = make(map[string]clipCacheEntry)
+	clipDir     string
+)
+
+// Return the cached clip for key if it is younger than clipCacheTTL
+func loadClipCache(key string) (string, bool) {
+	clipCacheMu.Lock()
+	defer clipCacheMu.Unlock()
+
+	entry, ok := clipCache[key]
+	if !ok || time.Since(entry.createdAt) >= clipCacheTTL {
+		return "", false
+	}
+	return entry.path, true
+}
+
+// Cache a generated clip, deleting expired clips and, past
+// maxCachedClips, the oldest ones
+func storeClipCache(key, path string) {
+	clipCacheMu.Lock()
+	defer clipCacheMu.Unlock()
+
+	for k, entry := range clipCache {
+		if time.Since(entry.createdAt) >= clipCacheTTL {
+			os.Remove(entry.path)
+			delete(clipCache, k)
+		}
+	}
+	for len(clipCache) >= maxCachedClips {
+		oldestKey := ""
+		for k, entry := range clipCache {
+			if oldestKey == "" || entry.createdAt.Before(clipCache[oldestKey].createdAt) {
+				oldestKey = k
+			}
+		}
+		os.Remove(clipCache[oldestKey].path)
+		delete(clipCache, oldestKey)
+	}
+	if old, ok := clipCache[key]; ok && old.path != path {
+		os.Remove(old.path)
+	}
+	clipCache[key] = clipCacheEntry{path: path, createdAt: time.Now()}
+}
+
+// Directory generated clips are written to. It carries the temp dir
+// prefix so leftovers are removed on the next start.
+func clipDirectory() (string, error) {
+	clipCacheMu.Lock()
+	defer clipCacheMu.Unlock()
+
+	if clipDir == "" {
+		dir, err := os.MkdirTemp("", tempDirPrefix()+"clips-*")
+		if err != nil {
+			return "", err
+		}
+		clipDir = dir
+	}
+	return clipDir, nil
+}
+
+// Handler for GET /api/v1/torrent/{sessionId}/clip?file=<idx>&start=<sec>&end=<sec>
+// Cuts the time range out of a video with ffmpeg and returns it as an MP4.
+// ffmpeg reads the file through this server's own stream endpoint, so it
+// can seek with Range requests and only the pieces around the clip are
+// prioritized and downloaded.
+func clipHandler(w http.ResponseWriter, r *http.Request, sessionID string, session *TorrentSession) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	fileIndex, err := strconv.Atoi(r.URL.Query().Get("file"))
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid file index"})
+		return
+	}
+	files := session.Torrent.Files()
+	if fileIndex < 0 || fileIndex >= len(files) {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "File index out of range"})
+		return
+	}
+	file := files[fileIndex]
+	if !strings.HasPrefix(contentTypeFor[strings.ToLower(filepath.Ext(file.DisplayPath()))], "video/") {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "File is not a video"})
+		return
+	}
+
+	start, err := strconv.ParseFloat(r.URL.Query().Get("start"), 64)
+	if err != nil || start < 0 {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid start time"})
+		return
+	}
+	end, err := strconv.ParseFloat(r.URL.Query().Get("end"), 64)
+	if err != nil || end <= start {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid end time"})
+		return
+	}
+	if end-start > maxClipSeconds {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Clips can be at most %d seconds long", maxClipSeconds)})
+		return
+	}
+
+	ffmpegPath, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		respondWithJSON(w, http.StatusNotImplemented, map[string]string{"error": "ffmpeg is not installed"})
+		return
+	}
+
+	key := fmt.Sprintf("%s/%d/%g-%g", session.Torrent.InfoHash().HexString(), fileIndex, start, end)
+	clipPath, ok := loadClipCache(key)
+	if ok {
+		w.Header().Set("X-Cache", "HIT")
+	} else {
+		clipPath, err = generateClip(r.Context(), ffmpegPath, sessionID, fileIndex, start, end)
+		if err != nil {
+			requestLogger(r.Context()).Printf("Clipping %s failed: %v", file.DisplayPath(), err)
+			respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to generate clip"})
+			return
+		}
+		storeClipCache(key, clipPath)
+		w.Header().Set("X-Cache", "MISS")
+	}
+
+	clip, err := os.Open(clipPath)
+	if err != nil {
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Clip is no longer available"})
+		return
+	}
+	defer clip.Close()
+
+	name := strings.TrimSuffix(filepath.Base(file.DisplayPath()), filepath.Ext(file.DisplayPath()))
+	w.Header().Set("Content-Type", "video/mp4")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": fmt.Sprintf("%s-%g-%g.mp4", name, start, end),
+	}))
+	http.ServeContent(w, r, "", time.Time{}, clip)
+}
+
+// Run ffmpeg over the session's stream endpoint and write the clip to a
+// new file in the clip directory
+func generateClip(ctx context.Context, ffmpegPath, sessionID string, fileIndex int, start, end float64) (string, error) {
+	dir, err := clipDirectory()
+	if err != nil {
+		return "", err
+	}
+	out, err := os.CreateTemp(dir, "clip-*.mp4")
+	if err != nil {
+		return "", err
+	}
+	out.Close()
+
+	input := fmt.Sprintf("%s/api/v1/torrent/%s/stream/%d", localBaseURL, url.PathEscape(sessionID), fileIndex)
+	// -ss before -i seeks the input, so ffmpeg jumps straight to the
+	// nearest keyframe instead of decoding everything before it
+	args := []string{"-hide_banner", "-loglevel", "error", "-y",
+		"-ss", strconv.FormatFloat(start, 'f', -1, 64),
+		"-i", input,
+		"-t", strconv.FormatFloat(end-start, 'f', -1, 64),
+		"-c:v", "libx264", "-preset", "veryfast",
+		"-c:a", "aac", "-b:a", "128k",
+		"-movflags", "+faststart",
+		"-f", "mp4", out.Name(),
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ffmpegPath, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		os.Remove(out.Name())
+		return "", fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return out.Name(), nil
+}
+
+// Containers and codecs browsers play natively
+var (
+	browserContainers  = []string{".mp4", ".webm"}
+	browserVideoCodecs = []string{"h264", "vp8", "vp9", "av1"}
+	browserAudioCodecs = []string{"aac", "mp3", "opus", "vorbis", "flac"}
+)
+
+// Handler for GET /api/v1/torrent/{sessionId}/playable
+// Picks the largest video as the main file and reports whether it is
+// likely to play in a browser without transcoding, judged from its tracks
+// when ffprobe is installed and from its extension otherwise. Also returns
+// the URLs the player needs, so the UI can warn before opening it.
+func playableHandler(w http.ResponseWriter, r *http.Request, sessionID string, session *TorrentSession) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	files := session.Torrent.Files()
+	mainIndex := -1
+	var subtitles []map[string]interface{}
+	for i, file := range files {
+		name := file.DisplayPath()
+		if !streamExtensionAllowed(name) {
+			continue
+		}
+		extension := strings.ToLower(filepath.Ext(name))
+		switch {
+		case strings.HasPrefix(contentTypeFor[extension], "video/"):
+			if mainIndex < 0 || file.Length() > files[mainIndex].Length() {
+				mainIndex = i
+			}
+		case extension == ".srt" || extension == ".vtt":
+			subtitles = append(subtitles, map[string]interface{}{
+				"index": i,
+				"name":  name,
+				"url":   fmt.Sprintf("/api/v1/torrent/%s/stream/%d.vtt?format=vtt", sessionID, i),
+			})
+		}
+	}
+	if mainIndex < 0 {
+		respondWithJSON(w, http.StatusNotFound, map[string]string{"error": "No video file found"})
+		return
+	}
+	mainFile := files[mainIndex]
+	streamURL := fmt.Sprintf("/api/v1/torrent/%s/stream/%d", sessionID, mainIndex)
+
+	extension := strings.ToLower(filepath.Ext(mainFile.DisplayPath()))
+	playable := slices.Contains(browserContainers, extension)
+	reason := ""
+	if !playable {
+		reason = "container " + extension + " is not supported by browsers"
+	}
+	method := "extension"
+
+	// Look at the actual codecs when possible; an MP4 may still hold HEVC
+	if ffprobePath, err := exec.LookPath("ffprobe"); err == nil && playable {
+		ctx, cancel := context.WithTimeout(r.Context(), mediaProbeTimeout)
+		info, err := probeMedia(ctx, ffprobePath, sessionID, mainIndex)
+		cancel()
+		if err != nil {
+			requestLogger(r.Context()).Printf("Probing %s failed, judging by extension: %v", mainFile.DisplayPath(), err)
+		} else {
+			method = "ffprobe"
+			playable, reason = browserCanPlay(info)
+		}
+	}
+
+	response := map[string]interface{}{
+		"file": map[string]interface{}{
+			"index": mainIndex,
+			"name":  mainFile.DisplayPath(),
+			"size":  mainFile.Length(),
+		},
+		"playable":  playable,
+		"checkedBy": method,
+		"streamUrl": streamURL,
+		"subtitles": subtitles,
+	}
+	if !playable {
+		response["reason"] = reason
+		if _, err := exec.LookPath("ffmpeg"); err == nil {
+			response["transcodeUrl"] = streamURL + "?transcode=720p"
+		}
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// Whether every video and audio track uses a codec browsers decode, and
+// if not, why
+func browserCanPlay(info *MediaInfo) (bool, string) {
+	for _, track := range info.Tracks {
+		switch track.Type {
+		case "video":
+			if !slices.Contains(browserVideoCodecs, track.Codec) {
+				return false, "video codec " + track.Codec + " is not supported by browsers"
+			}
+		case "audio":
+			// Players pick the default track, so only that one has to work
+			if track.Default && !slices.Contains(browserAudioCodecs, track.Codec) {
+				return false, "audio codec " + track.Codec + " is not supported by browsers"
+			}
+		}
+	}
+	return true, ""
+}
+
+// A track in a media container, as reported by ffprobe
+type MediaTrack struct {
+	Index    int    `json:"index"`
+	Type     string `json:"type"` // video, audio or subtitle
+	Codec    string `json:"codec"`
+	Language string `json:"language,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Default  bool   `json:"default"`
+	Width    int    `json:"width,omitempty"`
+	Height   int    `json:"height,omitempty"`
+	Channels int    `json:"channels,omitempty"`
+}
+
+// Container and tracks of a media file
+type MediaInfo struct {
+	Container string       `json:"container"`
+	Duration  float64      `json:"duration,omitempty"` // Seconds
+	Tracks    []MediaTrack `json:"tracks"`
+}
+
+// Handler for GET /api/v1/torrent/{sessionId}/mediainfo?file=<idx>
+// Probes the file with ffprobe so players can offer audio and subtitle
+// track selection. Like clips, ffprobe reads through the stream endpoint
+// and only fetches the parts of the file it looks at.
+func mediaInfoHandler(w http.ResponseWriter, r *http.Request, sessionID string, session *TorrentSession) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	fileIndex, err := strconv.Atoi(r.URL.Query().Get("file"))
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid file index"})
+		return
+	}
+	files := session.Torrent.Files()
+	if fileIndex < 0 || fileIndex >= len(files) {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "File index out of range"})
+		return
+	}
+
+	ffprobePath, err := exec.LookPath("ffprobe")
+	if err != nil {
+		respondWithJSON(w, http.StatusNotImplemented, map[string]string{"error": "ffprobe is not installed"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), mediaProbeTimeout)
+	defer cancel()
+
+	info, err := probeMedia(ctx, ffprobePath, sessionID, fileIndex)
+	if err != nil {
+		requestLogger(r.Context()).Printf("Probing %s failed: %v", files[fileIndex].DisplayPath(), err)
+		respondWithJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "Failed to read media info"})
+		return
+	}
+	// Later streams with ?prebuffer=auto use the real bitrate right away
+	if info.Duration > 0 {
+		session.setFileDuration(fileIndex, info.Duration)
+	}
+
+	respondWithJSON(w, http.StatusOK, info)
+}
+
+// Run ffprobe over the session's stream endpoint and collect the tracks
+func probeMedia(ctx context.Context, ffprobePath, sessionID string, fileIndex int) (*MediaInfo, error) {
+	input := fmt.Sprintf("%s/api/v1/torrent/%s/stream/%d", localBaseURL, url.PathEscape(sessionID), fileIndex)
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ffprobePath, "-v", "error", "-print_format", "json", "-show_format", "-show_streams", input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var probe struct {
+		Format struct {
+			FormatName string `json:"format_name"`
+			Duration   string `json:"duration"`
+		} `json:"format"`
+		Streams []struct {
+			Index       int               `json:"index"`
+			CodecType   string            `json:"codec_type"`
+			CodecName   string            `json:"codec_name"`
+			Width       int               `json:"width"`
+			Height      int               `json:"height"`
+			Channels    int               `json:"channels"`
+			Tags        map[string]string `json:"tags"`
+			Disposition map[string]int    `json:"disposition"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &probe); err != nil {
+		return nil, fmt.Errorf("parsing ffprobe output: %w", err)
+	}
+
+	info := &MediaInfo{Container: probe.Format.FormatName, Tracks: []MediaTrack{}}
+	info.Duration, _ = strconv.ParseFloat(probe.Format.Duration, 64)
+	for _, stream := range probe.Streams {
+		// Skip data and attachment streams such as embedded fonts
+		if stream.CodecType != "video" && stream.CodecType != "audio" && stream.CodecType != "subtitle" {
+			continue
+		}
+		// Cover art shows up as a single-frame video stream
+		if stream.Disposition["attached_pic"] == 1 {
+			continue
+		}
+		info.Tracks = append(info.Tracks, MediaTrack{
+			Index:    stream.Index,
+			Type:     stream.CodecType,
+			Codec:    stream.CodecName,
+			Language: stream.Tags["language"],
+			Title:    stream.Tags["title"],
+			Default:  stream.Disposition["default"] == 1,
+			Width:    stream.Width,
+			Height:   stream.Height,
+			Channels: stream.Channels,
+		})
+	}
+	return info, nil
+}
+
+// Detect a file's type from its first bytes, like http.ServeContent would.
+// Only the sniff prefix is read, so at most the first piece is downloaded.
+func sniffContentType(ctx context.Context, file *torrent.File) string {
+	reader := file.NewReader()
+	defer reader.Close()
+	reader.SetReadahead(0)
+
+	buf := make([]byte, min(int64(sniffLength), file.Length()))
+	n := 0
+	for n < len(buf) {
+		read, err := reader.ReadContext(ctx, buf[n:])
+		n += read
+		if err != nil {
+			break
+		}
+	}
+	if n == 0 {
+		return "application/octet-stream"
+	}
+	return http.DetectContentType(buf[:n])
+}
+
+// Handler for POST /api/v1/torrent/{sessionId}/recheck
+func recheckHandler(w http.ResponseWriter, r *http.Request, session *TorrentSession) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !session.rechecking.CompareAndSwap(false, true) {
+		respondWithJSON(w, http.StatusConflict, map[string]string{"error": "Recheck already in progress"})
+		return
+	}
+
+	// Verification hashes every piece, so run it in the background;
+	// pieces that fail are marked incomplete and downloaded again
+	go func() {
+		defer session.rechecking.Store(false)
+		requestLogger(r.Context()).Printf("Rechecking data for session %s", session.Torrent.InfoHash().HexString())
+		session.Torrent.VerifyData()
+		requestLogger(r.Context()).Printf("Recheck finished for session %s", session.Torrent.InfoHash().HexString())
+	}()
+
+	respondWithJSON(w, http.StatusAccepted, map[string]string{"message": "Recheck started"})
+}
+
+// Handler for /api/v1/torrent/{sessionId}/metainfo
+func metainfoHandler(w http.ResponseWriter, r *http.Request, session *TorrentSession) {
+	if session.Torrent.Info() == nil {
+		respondWithJSON(w, http.StatusConflict, map[string]string{"error": "Torrent metadata not available yet"})
+		return
+	}
+
+	var buf bytes.Buffer
+	mi := session.Torrent.Metainfo()
+	if err := mi.Write(&buf); err != nil {
+		requestLogger(r.Context()).Printf("Error encoding metainfo: %v", err)
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to encode torrent file"})
+		return
+	}
+
+	fileName := session.Torrent.Name() + ".torrent"
+	w.Header().Set("Content-Type", "application/x-bittorrent")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileName}))
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Write(buf.Bytes())
+}
+
+// Handler for /api/v1/torrent/{sessionId}/stats
+// Polling it keeps the session alive, like any other session request.
+// bytesCompleted and length are deprecated: they repeat completed and
+// total, and are only kept for clients written against the older payload.
+func sessionStatsHandler(w http.ResponseWriter, r *http.Request, session *TorrentSession) {
+	bytesRead := session.BytesRead()
+
+	// Pieces waiting for or undergoing hash verification
+	piecesChecking := 0
+	for _, run := range session.Torrent.PieceStateRuns() {
+		if run.Hashing || run.QueuedForHash || run.Checking {
+			piecesChecking += run.Length
+		}
+	}
+
+	// Per-file completion, for progress bars on the file list
+	files := session.Torrent.Files()
+	fileProgress := make([]FileProgress, len(files))
+	for i, file := range files {
+		percent := 100.0
+		if file.Length() > 0 {
+			percent = float64(file.BytesCompleted()) / float64(file.Length()) * 100
+		}
+		fileProgress[i] = FileProgress{Index: i, Percent: percent}
+	}
+
+	completed := session.Torrent.BytesCompleted()
+	total := session.Torrent.Length()
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"name":             session.Torrent.Name(),
+		"infoHash":         session.Torrent.InfoHash().HexString(),
+		"completed":        completed,
+		"total":            total,
+		"downloadRate":     session.DownloadRate(),
+		"peers":            session.Torrent.Stats().ActivePeers,
+		"files":            fileProgress,
+		"rechecking":       session.rechecking.Load(),
+		"piecesChecking":   piecesChecking,
+		"bytesDownloaded":  bytesRead,
+		"downloaded":       formatSize(float64(bytesRead)),
+		"bytesCompleted":   completed, // Deprecated: use completed
+		"length":           total,     // Deprecated: use total
+		"createdAt":        session.CreatedAt,
+		"bandwidthHistory": session.BandwidthHistory(),
+	})
+}
+
+// How much of one file in a torrent is downloaded
+type FileProgress struct {
+	Index   int     `json:"index"`
+	Percent float64 `json:"percent"`
+}
+
+// Handler for GET /api/v1/torrent/{sessionId}/eta
+// etaSeconds is null while nothing is being downloaded
+func etaHandler(w http.ResponseWriter, r *http.Request, session *TorrentSession) {
+	remaining := session.Torrent.Length() - session.Torrent.BytesCompleted()
+	rate := session.DownloadRate()
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"remainingBytes": remaining,
+		"bytesPerSecond": rate,
+		"etaSeconds":     estimateETA(remaining, rate),
+	})
+}
+
+// A connected peer as reported by /peers
+type PeerInfo struct {
+	Address      string  `json:"address"`
+	Client       string  `json:"client,omitempty"`
+	Network      string  `json:"network"`
+	Source       string  `json:"source"`       // How the peer was found: Tr tracker, Hg/Ha DHT, X PEX, I incoming, M magnet
+	DownloadRate float64 `json:"downloadRate"` // Bytes per second
+}
+
+// Handler for GET /api/v1/torrent/{sessionId}/peers
+// Lists the connected peers, fastest first. With privacy mode on, peer
+// addresses are replaced by a salted hash so peers can still be told apart.
+func peersHandler(w http.ResponseWriter, r *http.Request, session *TorrentSession) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	settingsMutex.RLock()
+	privacyMode := currentSettings.PrivacyMode
+	salt := currentSettings.InstanceID
+	settingsMutex.RUnlock()
+
+	peers := []PeerInfo{}
+	for _, conn := range session.Torrent.PeerConns() {
+		address := conn.RemoteAddr.String()
+		if privacyMode {
+			sum := sha256.Sum256([]byte(salt + address))
+			address = "peer-" + hex.EncodeToString(sum[:6])
+		}
+		client, _ := conn.PeerClientName.Load().(string)
+		peers = append(peers, PeerInfo{
+			Address:      address,
+			Client:       client,
+			Network:      conn.Network,
+			Source:       string(conn.Discovery),
+			DownloadRate: conn.DownloadRate(),
+		})
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].DownloadRate > peers[j].DownloadRate
+	})
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"count": len(peers),
+		"peers": peers,
+	})
+}
+
+// Handler for POST /api/v1/torrent/{sessionId}/reannounce
+// anacrolix only announces on its own schedule, so announce to every known
+// tracker here and feed the returned peers to the torrent, and start a
+// fresh DHT lookup in the background.
+func reannounceHandler(w http.ResponseWriter, r *http.Request, session *TorrentSession) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	last := time.Unix(0, session.lastReannounce.Load())
+	if wait := reannounceCooldown - time.Since(last); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		respondWithJSON(w, http.StatusTooManyRequests, map[string]string{"error": "Re-announced too recently"})
+		return
+	}
+	session.lastReannounce.Store(time.Now().UnixNano())
+
+	t := session.Torrent
+	dhtServers := session.Client.DhtServers()
+	for _, server := range dhtServers {
+		done, stop, err := t.AnnounceToDht(server)
+		if err != nil {
+			requestLogger(r.Context()).Printf("DHT announce failed: %v", err)
+			continue
+		}
+		go func() {
+			defer stop()
+			select {
+			case <-done:
+			case <-time.After(reannounceDHTTimeout):
+			}
+		}()
+	}
+
+	// Only HTTP(S) announces can go through the proxy; anything else would
+	// leave from the real address, so skip it while the proxy is on
+	trackers := sessionTrackers(t)
+	var skipped []string
+	settingsMutex.RLock()
+	proxyOn := len(proxyChain()) > 0
+	settingsMutex.RUnlock()
+	if proxyOn {
+		for _, trackerURL := range trackers {
+			if !isHTTPTracker(trackerURL) {
+				skipped = append(skipped, trackerURL)
+			}
+		}
+		trackers = slices.DeleteFunc(trackers, func(trackerURL string) bool { return !isHTTPTracker(trackerURL) })
+	}
+
+	var responded, peersFound atomic.Int64
+	var wg sync.WaitGroup
+	for _, trackerURL := range trackers {
+		wg.Add(1)
+		go func(trackerURL string) {
+			defer wg.Done()
+			n, err := announceToTracker(r.Context(), session, trackerURL)
+			if err != nil {
+				requestLogger(r.Context()).Printf("Re-announce to %s failed: %v", trackerURL, err)
+				return
+			}
+			responded.Add(1)
+			peersFound.Add(int64(n))
+		}(trackerURL)
+	}
+	wg.Wait()
+
+	response := map[string]interface{}{
+		"trackersContacted": len(trackers),
+		"trackersResponded": responded.Load(),
+		"peersFound":        peersFound.Load(),
+		"dhtServers":        len(dhtServers),
+	}
+	if len(skipped) > 0 {
+		response["skippedTrackers"] = skipped
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// Distinct tracker URLs known for the torrent
+func sessionTrackers(t *torrent.Torrent) []string {
+	mi := t.Metainfo()
+	seen := make(map[string]bool)
+	var trackers []string
+	for _, tier := range mi.UpvertedAnnounceList() {
+		for _, trackerURL := range tier {
+			if trackerURL != "" && !seen[trackerURL] {
+				seen[trackerURL] = true
+				trackers = append(trackers, trackerURL)
+			}
+		}
+	}
+	return trackers
+}
+
+// Whether announces to the tracker go over HTTP(S), which the proxy can carry
+func isHTTPTracker(trackerURL string) bool {
+	lower := strings.ToLower(trackerURL)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+// Announce the session to one tracker, through the proxy if one is on,
+// and add the peers it returns. Returns how many peers it returned.
+func announceToTracker(ctx context.Context, session *TorrentSession, trackerURL string) (int, error) {
+	u, err := url.Parse(trackerURL)
+	if err != nil {
+		return 0, err
+	}
+
+	t := session.Torrent
+	left := int64(-1) // Unknown until the info arrives
+	if t.Info() != nil {
+		left = t.Length() - t.BytesCompleted()
+	}
+
+	announce := tracker.Announce{
+		TrackerUrl: trackerURL,
+		Request: tracker.AnnounceRequest{
+			InfoHash: t.InfoHash(),
+			PeerId:   session.Client.PeerID(),
+			Left:     left,
+			Event:    tracker.None,
+			NumWant:  -1,
+			Port:     uint16(session.Port),
+		},
+		UdpNetwork: u.Scheme,
+	}
+
+	// DialContext only covers HTTP; UDP announces would bypass the proxy
+	settingsMutex.RLock()
+	chain := proxyChain()
+	settingsMutex.RUnlock()
+	if len(chain) > 0 {
+		if !isHTTPTracker(trackerURL) {
+			return 0, errors.New("only HTTP(S) trackers can be reached through the proxy")
+		}
+		proxyDialer, err := createProxyDialer(chain...)
+		if err != nil {
+			return 0, err
+		}
+		announce.DialContext = proxyDialContext(proxyDialer)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, tracker.DefaultTrackerAnnounceTimeout)
+	defer cancel()
+	announce.Context = ctx
+
+	res, err := announce.Do()
+	if err != nil {
+		return 0, err
+	}
+
+	peers := make([]torrent.PeerInfo, 0, len(res.Peers))
+	for _, p := range res.Peers {
+		peer := torrent.PeerInfo{
+			Addr:   &net.TCPAddr{IP: p.IP, Port: p.Port},
+			Source: torrent.PeerSourceTracker,
+		}
+		copy(peer.Id[:], p.ID)
+		peers = append(peers, peer)
+	}
+	t.AddPeers(peers)
+	return len(res.Peers), nil
+}
+
+// Seconds until remaining bytes are done at the given rate, nil when the
+// rate is zero. Already finished downloads have an ETA of zero.
+func estimateETA(remaining int64, bytesPerSecond float64) *float64 {
+	eta := 0.0
+	if remaining <= 0 {
+		return &eta
+	}
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	eta = float64(remaining) / bytesPerSecond
+	return &eta
+}
+
+// Handler for /api/v1/torrent/{sessionId}/events
+// Streams progress as server-sent events and emits a "stalled" event
+// when the watchdog sees no download progress while a stream is open.
+func sessionEventsHandler(w http.ResponseWriter, r *http.Request, session *TorrentSession) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	sendEvent := func(event string, data interface{}) {
+		payload, _ := json.Marshal(data)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+		flusher.Flush()
+	}
+
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	reportedStall := false
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-session.Torrent.Closed():
+			sendEvent("closed", map[string]string{"message": "Session closed"})
+			return
+		case <-ticker.C:
+		}
+
+		// An open event stream means the player is still in use
+		session.LastUsed = time.Now()
+
+		stats := session.Torrent.Stats()
+		sendEvent("progress", map[string]interface{}{
+			"bytesCompleted": session.Torrent.BytesCompleted(),
+			"length":         session.Torrent.Length(),
+			"activePeers":    stats.ActivePeers,
+		})
+
+		stalledSince := session.StalledSince()
+		if !stalledSince.IsZero() && !reportedStall {
+			sendEvent("stalled", map[string]interface{}{
+				"error":        "Torrent is stalled - no download progress",
+				"stalledSince": stalledSince,
+			})
+		}
+		reportedStall = !stalledSince.IsZero()
+	}
+}
+
+// Handler for /api/v1/torrent/{sessionId}/pieces
+func piecesHandler(w http.ResponseWriter, r *http.Request, session *TorrentSession) {
+	numPieces := session.Torrent.NumPieces()
+	bitfield := completedPiecesBitfield(session.Torrent.PieceStateRuns(), numPieces)
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"numPieces":      numPieces,
+		"pieceLength":    session.Torrent.Info().PieceLength,
+		"piecesComplete": session.Torrent.Stats().PiecesComplete,
+		"bitfield":       base64.StdEncoding.EncodeToString(bitfield),
+	})
+}
+
+// Pack completed pieces into a bitfield, high bit first like the BitTorrent wire format
+func completedPiecesBitfield(runs torrent.PieceStateRuns, numPieces int) []byte {
+	bitfield := make([]byte, (numPieces+7)/8)
+	index := 0
+	for _, run := range runs {
+		if run.Complete {
+			for i := index; i < index+run.Length && i < numPieces; i++ {
+				bitfield[i/8] |= 0x80 >> (i % 8)
+			}
+		}
+		index += run.Length
+	}
+	return bitfield
+}
+
+// Handler for /api/v1/torrent/{sessionId}/ready?file=<idx>[&duration=<seconds>]
+func fileReadyHandler(w http.ResponseWriter, r *http.Request, session *TorrentSession) {
+	fileIndex, err := strconv.Atoi(r.URL.Query().Get("file"))
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid file index"})
+		return
+	}
+
+	files := session.Torrent.Files()
+	if fileIndex < 0 || fileIndex >= len(files) {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "File index out of range"})
+		return
+	}
+	file := files[fileIndex]
+
+	settingsMutex.RLock()
+	prebufferSeconds := currentSettings.PrebufferSeconds
+	settingsMutex.RUnlock()
+
+	// Estimate the bitrate from size/duration when the player knows the
+	// duration, or an earlier stream probed it
+	bitrate := float64(defaultStreamBitrate)
+	if duration, err := strconv.ParseFloat(r.URL.Query().Get("duration"), 64); err == nil && duration > 0 {
+		bitrate = float64(file.Length()) / duration
+	} else if duration, ok := session.fileDuration(fileIndex); ok {
+		bitrate = float64(file.Length()) / duration
+	}
+
+	// Make sure the pieces covering the prebuffer window are actually being fetched
+	prebufferBytes := prebufferBytesFor(file, bitrate, prebufferSeconds)
+	if pieceLength := session.Torrent.Info().PieceLength; pieceLength > 0 && prebufferBytes > 0 {
+		endPiece := int((file.Offset() + prebufferBytes - 1) / pieceLength)
+		session.Torrent.DownloadPieces(file.BeginPieceIndex(), endPiece+1)
+	}
+
+	bufferedBytes := contiguousBytesFromStart(session.Torrent, file)
+	bufferedSeconds := float64(bufferedBytes) / bitrate
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"fileIndex":        fileIndex,
+		"ready":            bufferedBytes >= prebufferBytes,
+		"bufferedBytes":    bufferedBytes,
+		"bufferedSeconds":  bufferedSeconds,
+		"prebufferSeconds": prebufferSeconds,
+	})
+}
+
+// Bytes at the start of a file that cover seconds of playback at bitrate
+func prebufferBytesFor(file *torrent.File, bitrate float64, seconds int) int64 {
+	return min(int64(float64(seconds)*bitrate), file.Length())
+}
+
+// Average bitrate of a file in bytes/sec, from its size and the duration
+// ffprobe reports. Until a duration is known defaultStreamBitrate is
+// assumed and a probe starts in the background, so streams never wait on
+// ffprobe and later ones get the real bitrate.
+func streamBitrate(session *TorrentSession, file *torrent.File) float64 {
+	fileIndex := slices.Index(session.Torrent.Files(), file)
+	if duration, ok := session.fileDuration(fileIndex); ok {
+		return float64(file.Length()) / duration
+	}
+	if fileIndex >= 0 {
+		go probeFileDuration(session, file, fileIndex)
+	}
+	return defaultStreamBitrate
+}
+
+// Probe a file's duration for streamBitrate, unless a probe for it is
+// already running
+func probeFileDuration(session *TorrentSession, file *torrent.File, fileIndex int) {
+	session.durationsMu.Lock()
+	if session.probingFiles[fileIndex] {
+		session.durationsMu.Unlock()
+		return
+	}
+	if session.probingFiles == nil {
+		session.probingFiles = make(map[int]bool)
+	}
+	session.probingFiles[fileIndex] = true
+	session.durationsMu.Unlock()
+
+	defer func() {
+		session.durationsMu.Lock()
+		delete(session.probingFiles, fileIndex)
+		session.durationsMu.Unlock()
+	}()
+
+	ffprobePath, err := exec.LookPath("ffprobe")
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), bitrateProbeTimeout)
+	defer cancel()
+	info, err := probeMedia(ctx, ffprobePath, session.Torrent.InfoHash().HexString(), fileIndex)
+	if err != nil || info.Duration <= 0 {
+		log.Printf("No duration for %s, streams keep the default bitrate", file.DisplayPath())
+		return
+	}
+	session.setFileDuration(fileIndex, info.Duration)
+}
+
+// Hold until the first prebufferBytes of the file are downloaded, so
+// playback starts smoothly. Gives up after prebufferTimeout so a slow
+// swarm still gets a stream.
+func waitForPrebuffer(ctx context.Context, t *torrent.Torrent, file *torrent.File, prebufferBytes int64) {
+	pieceLength := t.Info().PieceLength
+	if pieceLength <= 0 {
+		return
+	}
+	endPiece := int((file.Offset() + prebufferBytes - 1) / pieceLength)
+	t.DownloadPieces(file.BeginPieceIndex(), endPiece+1)
+
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(prebufferTimeout)
+
+	for contiguousBytesFromStart(t, file) < prebufferBytes {
+		select {
+		case <-ticker.C:
+		case <-timeout:
+			log.Printf("Prebuffer timed out for %s, starting stream anyway", file.DisplayPath())
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Count the bytes at the start of a file that are backed by completed pieces
+func contiguousBytesFromStart(t *torrent.Torrent, file *torrent.File) int64 {
+	pieceLength := t.Info().PieceLength
+	if pieceLength <= 0 || file.Length() == 0 {
+		return 0
+	}
+
+	fileEnd := file.Offset() + file.Length()
+	var buffered int64
+	for i := file.BeginPieceIndex(); i < file.EndPieceIndex(); i++ {
+		if !t.PieceState(i).Complete {
+			break
+		}
+		buffered = min(int64(i+1)*pieceLength, fileEnd) - file.Offset()
+	}
+	return buffered
+}
+
+// Add a function to convert SRT to VTT format. With keepIDs the SRT cue
+// numbers become VTT cue identifiers instead of being dropped.
+func convertSRTtoVTT(srtBytes []byte, keepIDs bool) []byte {
+	srtContent := string(srtBytes)
+
+	// Add VTT header
+	vttContent := "WEBVTT\n\n"
+
+	// Convert SRT content to VTT format
+	// Simple conversion - replace timestamps format
+	lines := strings.Split(srtContent, "\n")
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		// A number is a cue index only when a timestamp line follows it;
+		// otherwise it is subtitle text that happens to be numeric
+		if _, err := strconv.Atoi(strings.TrimSpace(line)); err == nil &&
+			i+1 < len(lines) && strings.Contains(lines[i+1], " --> ") {
+			if keepIDs {
+				vttContent += strings.TrimSpace(line) + "\n"
+			}
+			continue
+		}
+
+		// Convert timestamp lines
+		if strings.Contains(line, " --> ") {
+			// SRT: 00:00:20,000 --> 00:00:24,400
+			// VTT: 00:00:20.000 --> 00:00:24.400
+			line = strings.Replace(line, ",", ".", -1)
+			vttContent += line + "\n"
+		} else {
+			vttContent += line + "\n"
+		}
+	}
+
+	return []byte(vttContent)
+}
+
+// Helper function to respond with JSON
+func respondWithJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+// Tear down a session and release everything it holds.
+// Returns the total bytes the session downloaded.
+func closeSession(sessionID string, session *TorrentSession) int64 {
+	bytesRead := session.BytesRead()
+
+	// Remove from map first so concurrent callers don't tear it down twice
+	if !sessions.CompareAndDelete(sessionID, session) {
+		return bytesRead
+	}
+
+	// Drop torrent first
+	session.Torrent.Drop()
+	// Close client
+	session.Client.Close()
+	// Release port
+	releasePort(session.Port)
+	// Remove temp directory
+	if session.TempDataDir != "" {
+		os.RemoveAll(session.TempDataDir)
+	}
+
+	return bytesRead
+}
+
+// Handler for POST /api/v1/torrent/reset
+// Tears down every session at once. Only allowed from the local machine.
+func resetSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !isLocalRequest(r) {
+		respondWithJSON(w, http.StatusForbidden, map[string]string{"error": "Reset is only allowed from localhost"})
+		return
+	}
+
+	cleaned := 0
+	sessions.Range(func(sessionID string, session *TorrentSession) bool {
+		closeSession(sessionID, session)
+		cleaned++
+		return true
+	})
+
+	// Force garbage collection to free memory
+	runtime.GC()
+
+	requestLogger(r.Context()).Printf("Reset cleaned up %d sessions", cleaned)
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "All sessions cleaned up",
+		"cleaned": cleaned,
+	})
+}
+
+// Whether the request comes from a loopback address
+func isLocalRequest(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// A listen port held by this server and the session using it, if any
+type PortAllocation struct {
+	Port      int    `json:"port"`
+	SessionID string `json:"sessionId,omitempty"`
+	Leaked    bool   `json:"leaked"` // Allocated but no live session uses it
+}
+
+// Handler for GET /api/v1/ports
+// Lists allocated listen ports so leaked ones can be spotted. Only allowed
+// from the local machine.
+func portsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !isLocalRequest(r) {
+		respondWithJSON(w, http.StatusForbidden, map[string]string{"error": "Port diagnostics are only allowed from localhost"})
+		return
+	}
+
+	ports := portAllocations()
+	leaked := 0
+	for _, allocation := range ports {
+		if allocation.Leaked {
+			leaked++
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"ports":  ports,
+		"total":  len(ports),
+		"leaked": leaked,
+	})
+}
+
+// Cross-reference usedPorts with the sessions holding them, sorted by port
+func portAllocations() []PortAllocation {
+	sessionByPort := make(map[int]string)
+	sessions.Range(func(sessionID string, session *TorrentSession) bool {
+		sessionByPort[session.Port] = sessionID
+		return true
+	})
+
+	ports := []PortAllocation{}
+	usedPorts.Range(func(key, value interface{}) bool {
+		port := key.(int)
+		sessionID, ok := sessionByPort[port]
+		ports = append(ports, PortAllocation{Port: port, SessionID: sessionID, Leaked: !ok})
+		return true
+	})
+
+	sort.Slice(ports, func(i, j int) bool { return ports[i].Port < ports[j].Port })
+	return ports
+}
+
+// Release ports no live session uses. A sync add holds its port without a
+// session while it waits for metadata, so recently allocated ports are kept.
+func reclaimLeakedPorts() {
+	for _, allocation := range portAllocations() {
+		if !allocation.Leaked {
+			continue
+		}
+		value, ok := usedPorts.Load(allocation.Port)
+		if !ok || time.Since(value.(time.Time)) < portLeakGracePeriod {
+			continue
+		}
+		releasePort(allocation.Port)
+		log.Printf("Reclaimed leaked port %d", allocation.Port)
+	}
+}
+
+// Temp disk space used by all sessions
+type DiskUsage struct {
+	TotalBytes int64              `json:"totalBytes"`
+	FreeBytes  int64              `json:"freeBytes"`
+	Sessions   []SessionDiskUsage `json:"sessions"`
+	ScannedAt  time.Time          `json:"scannedAt"`
+}
+
+type SessionDiskUsage struct {
+	SessionID string `json:"sessionId"`
+	Bytes     int64  `json:"bytes"`
+}
+
+var (
+	diskUsageMu    sync.Mutex
+	diskUsageCache *DiskUsage
+)
+
+// Handler for GET /api/v1/disk
+func diskUsageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, getDiskUsage())
+}
+
+// Walking large downloads is slow, so reuse a recent scan when there is one
+func getDiskUsage() *DiskUsage {
+	diskUsageMu.Lock()
+	defer diskUsageMu.Unlock()
+
+	if diskUsageCache != nil && time.Since(diskUsageCache.ScannedAt) < diskUsageCacheTTL {
+		return diskUsageCache
+	}
+
+	usage := &DiskUsage{Sessions: []SessionDiskUsage{}, ScannedAt: time.Now()}
+	sessions.Range(func(sessionID string, session *TorrentSession) bool {
+		if session.TempDataDir == "" {
+			return true
+		}
+		used := dirSize(session.TempDataDir)
+		usage.Sessions = append(usage.Sessions, SessionDiskUsage{
+			SessionID: sessionID,
+			Bytes:     used,
+		})
+		usage.TotalBytes += used
+		return true
+	})
+
+	// Session dirs are all created under the temp dir
+	if free, err := diskFreeBytes(os.TempDir()); err == nil {
+		usage.FreeBytes = free
+	} else {
+		log.Printf("Error reading free disk space: %v", err)
+	}
+
+	diskUsageCache = usage
+	return usage
+}
+
+// Total size of the regular files under dir. Files that disappear
+// mid-walk (e.g. a session being closed) are skipped.
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// Periodically check streaming sessions for stalled downloads
+func watchStalls() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		settingsMutex.RLock()
+		timeout := time.Duration(currentSettings.StallTimeoutSeconds) * time.Second
+		settingsMutex.RUnlock()
+
+		sessions.Range(func(_ string, session *TorrentSession) bool {
+			session.checkStall(timeout)
+			return true
+		})
+	}
+}
+
+// Periodically sample how much each session has downloaded
+func trackBandwidth() {
+	ticker := time.NewTicker(bandwidthSampleInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		sessions.Range(func(_ string, session *TorrentSession) bool {
+			session.recordBandwidthSample()
+			return true
+		})
+	}
+}
+
+// Disable uploading/seeding unless the operator opted in to giving back
+// to the swarm for a while after streaming
+func configureSeeding(config *torrent.ClientConfig) {
+	seed := seedGracePeriod() > 0
+	config.NoUpload = !seed
+	config.Seed = seed
+
+	// Set upload rate to 0 to prevent any uploading. A seeding client
+	// keeps the default limiter, which the upload path needs.
+	if !seed {
+		config.UploadRateLimiter = nil
+	}
+}
+
+// How long new sessions seed after they were last used; zero unless
+// SeedAfterStream is on
+func seedGracePeriod() time.Duration {
+	settingsMutex.RLock()
+	defer settingsMutex.RUnlock()
+	if !currentSettings.SeedAfterStream {
+		return 0
+	}
+	return time.Duration(currentSettings.SeedDurationMinutes) * time.Minute
+}
+
+// Whether the session has been inactive for more than sessionIdleTimeout,
+// or past its seeding grace period when that is longer
+func (s *TorrentSession) idleExpired() bool {
+	return time.Since(s.LastUsed) > max(sessionIdleTimeout, s.SeedGrace)
+}
+
+// Update cleanupSessions with temp directory cleanup
+func cleanupSessions() {
+	ticker := time.NewTicker(sessionCleanupInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		cleaned := 0
+		sessions.Range(func(sessionID string, session *TorrentSession) bool {
+			if session.idleExpired() {
+				bytesRead := closeSession(sessionID, session)
+				log.Printf("Closed idle session %v (downloaded %s)", sessionID, formatSize(float64(bytesRead)))
+				cleaned++
+			}
+			return true
+		})
+
+		// Free ports whose session teardown never released them
+		reclaimLeakedPorts()
+
+		// Forget failed async adds nobody asked about
+		failedSessions.Range(func(key, value interface{}) bool {
+			if time.Since(value.(failedSession).FailedAt) > 10*time.Minute {
+				failedSessions.Delete(key)
+			}
+			return true
+		})
+
+		if cleaned > 0 {
+			// Force garbage collection to free memory
+			runtime.GC()
+		}
+	}
+}
+
+// Test the proxy connection
+func testProwlarrConnection(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	var settings ProwlarrSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	prowlarrHost := settings.ProwlarrHost
+	prowlarrApiKey := settings.ProwlarrApiKey
+
+	if prowlarrHost == "" || prowlarrApiKey == "" {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Prowlarr host or API key not set"})
+		return
+	}
+
+	responseBody, err := pingProwlarr(prowlarrHost, prowlarrApiKey)
+	if err != nil {
+		requestLogger(r.Context()).Printf("Prowlarr test failed: %v", err)
+		respondWithJSON(w, upstreamErrorStatus(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(responseBody)
+}
+
+// Error for an upstream service that answered with a non-200 status
+type upstreamStatusError struct {
+	service    string
+	statusCode int
+}
+
+func (e *upstreamStatusError) Error() string {
+	return fmt.Sprintf("%s returned status %d", e.service, e.statusCode)
+}
+
+// Status to answer with for a failed upstream check: the upstream's own
+// status when it answered, 500 when it couldn't be reached
+func upstreamErrorStatus(err error) int {
+	var statusErr *upstreamStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.statusCode
+	}
+	return http.StatusInternalServerError
+}
+
+// Call Prowlarr's system status endpoint and return its body
+func pingProwlarr(prowlarrHost, prowlarrApiKey string) ([]byte, error) {
+	client := getIndexerClient()
+	testURL := fmt.Sprintf("%s/api/v1/system/status", prowlarrHost)
+
+	req, err := http.NewRequest("GET", testURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("X-Api-Key", prowlarrApiKey)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to connect to Prowlarr: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &upstreamStatusError{service: "Prowlarr", statusCode: resp.StatusCode}
+	}
+
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.New("Failed to read Prowlarr response")
+	}
+	return responseBody, nil
+}
+
+// Search from Prowlarr
+func searchFromProwlarr(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Prowlarr-Host, X-Api-Key")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "No search query provided"})
+		return
+	}
+
+	minSeeders, err := parseMinSeeders(r)
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	// search movies in prowlarr
+	settingsMutex.RLock()
+	prowlarrHost := currentSettings.ProwlarrHost
+	prowlarrApiKey := currentSettings.ProwlarrApiKey
+	settingsMutex.RUnlock()
+
+	if prowlarrHost == "" || prowlarrApiKey == "" {
+		http.Error(w, "Prowlarr host or API key not set", http.StatusBadRequest)
+		return
+	}
+
+	// Reuse the shared indexer client for Prowlarr
+	client := getIndexerClient()
+
+	// Prowlarr search endpoint - looking for movie torrents
+	searchURL := fmt.Sprintf("%s/api/v1/search?query=%s&limit=%d", prowlarrHost, url.QueryEscape(query), prowlarrFetchLimit)
+
+	req, err := http.NewRequest("GET", searchURL, nil)
+	if err != nil {
+		requestLogger(r.Context()).Printf("Error creating request: %v", err)
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	req.Header.Set("X-Api-Key", prowlarrApiKey)
+	resp, err := client.Do(req)
+	if err != nil {
+		requestLogger(r.Context()).Printf("Error making request to Prowlarr: %v", err)
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to connect to Prowlarr: " + err.Error()})
+		return
+	}
+	defer resp.Body.Close()
+
+	// Read the response body
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		requestLogger(r.Context()).Printf("Error reading response: %v", err)
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to read Prowlarr response"})
+		return
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		respondWithJSON(w, resp.StatusCode, map[string]string{"error": fmt.Sprintf("Prowlarr returned status %d: %s", resp.StatusCode, string(body))})
+		return
+	}
+
+	// Parse the JSON response and process the results
+	var results []map[string]interface{}
+	if err := json.Unmarshal(body, &results); err != nil {
+		requestLogger(r.Context()).Printf("Error parsing JSON: %v", err)
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to parse Prowlarr response"})
+		return
+	}
+
+	// Process the results to make them more usable by the frontend
+	var processedResults []map[string]interface{}
+	for _, result := range results {
+		// Get title and download URL
+		title, hasTitle := result["title"].(string)
+		downloadUrl, hasDownloadUrl := result["downloadUrl"].(string)
+
+		// Magnet URL might be present in some results
+		magnetUrl, hasMagnet := result["magnetUrl"].(string)
+
+		if !hasTitle || title == "" {
+			// Skip results without titles
+			continue
+		}
+
+		// We need at least one of download URL or magnet URL
+		if (!hasDownloadUrl || downloadUrl == "") && (!hasMagnet || magnetUrl == "") {
+			continue
+		}
+
+		// Create a simplified result object with just what we need
+		processedResult := map[string]interface{}{
+			"title": title,
+		}
+
+		// Prefer magnet URLs if available directly
+		if hasMagnet && magnetUrl != "" {
+			processedResult["magnetUrl"] = magnetUrl
+			processedResult["directMagnet"] = true
+		} else if hasDownloadUrl && downloadUrl != "" {
+			processedResult["downloadUrl"] = downloadUrl
+			processedResult["directMagnet"] = false
+		}
+
+		// Include optional fields if they exist
+		if size, ok := result["size"].(float64); ok {
+			processedResult["size"] = formatSize(size)
+		}
+
+		if seeders, ok := result["seeders"].(float64); ok {
+			processedResult["seeders"] = seeders
+		}
+
+		if leechers, ok := result["leechers"].(float64); ok {
+			processedResult["leechers"] = leechers
+		}
+
+		if indexer, ok := result["indexer"].(string); ok {
+			processedResult["indexer"] = indexer
+		}
+
+		if publishDate, ok := result["publishDate"].(string); ok {
+			processedResult["publishDate"] = publishDate
+		}
+
+		if category, ok := result["category"].(string); ok {
+			processedResult["category"] = category
+		}
+
+		processedResults = append(processedResults, processedResult)
+	}
+
+	// Best seeded first, so the filtered results that are kept are the top ones
+	processedResults = filterByMinSeeders(sortBySeeders(processedResults), minSeeders)
+	if len(processedResults) > prowlarrMaxResults {
+		processedResults = processedResults[:prowlarrMaxResults]
+	}
+	respondWithJSON(w, http.StatusOK, searchResponse(query, "prowlarr", processedResults))
+}
+
+// Wrap search results with the query and count so the frontend can show
+// "N results for 'query'"
+func searchResponse(query, source string, results []map[string]interface{}) map[string]interface{} {
+	if results == nil {
+		results = []map[string]interface{}{}
+	}
+	return map[string]interface{}{
+		"query":   query,
+		"count":   len(results),
+		"source":  source,
+		"results": results,
+	}
+}
+
+// Read the optional minSeeders query parameter (0 means no filtering)
+func parseMinSeeders(r *http.Request) (int, error) {
+	param := r.URL.Query().Get("minSeeders")
+	if param == "" {
+		return 0, nil
+	}
+	minSeeders, err := strconv.Atoi(param)
+	if err != nil || minSeeders < 0 {
+		return 0, errors.New("invalid minSeeders value")
+	}
+	return minSeeders, nil
+}
+
+// Order search results by seeders, most first. Results without a seeder
+// count go last, and ties keep the indexer's order.
+func sortBySeeders(results []map[string]interface{}) []map[string]interface{} {
+	seeders := func(result map[string]interface{}) float64 {
+		if n, ok := result["seeders"].(float64); ok {
+			return n
+		}
+		return -1
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return seeders(results[i]) > seeders(results[j])
+	})
+	return results
+}
+
+// Drop search results with fewer seeders than the threshold.
+// Results without a seeder count are treated as having none.
+func filterByMinSeeders(results []map[string]interface{}, minSeeders int) []map[string]interface{} {
+	if minSeeders <= 0 {
+		return results
+	}
+
+	var filtered []map[string]interface{}
+	for _, result := range results {
+		if seeders, ok := result["seeders"].(float64); ok && seeders >= float64(minSeeders) {
+			filtered = append(filtered, result)
+		}
+	}
+	return filtered
+}
+
+// Test Jackett Connection Handler
+func testJackettConnection(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	var settings JackettSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	jackettHost := settings.JackettHost
+	jackettApiKey := settings.JackettApiKey
+
+	if jackettHost == "" || jackettApiKey == "" {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Jackett host or API key not set"})
+		return
+	}
+
+	responseBody, err := pingJackett(jackettHost, jackettApiKey)
+	if err != nil {
+		requestLogger(r.Context()).Printf("Jackett test failed: %v", err)
+		respondWithJSON(w, upstreamErrorStatus(err), map[string]string{"error": err.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(responseBody)
+}
+
+// Query Jackett's aggregate indexer and return its body
+func pingJackett(jackettHost, jackettApiKey string) ([]byte, error) {
+	client := getIndexerClient()
+	testURL := fmt.Sprintf("%s/api/v2.0/indexers/all/results?apikey=%s", jackettHost, jackettApiKey)
+	req, err := http.NewRequest("GET", testURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to connect to Jackett: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &upstreamStatusError{service: "Jackett", statusCode: resp.StatusCode}
+	}
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.New("Failed to read Jackett response")
+	}
+	return responseBody, nil
+}
+
+// Search from Jackett
+func searchFromJackett(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "No search query provided"})
+		return
+	}
+
+	minSeeders, err := parseMinSeeders(r)
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	// search movies in jackett
+	settingsMutex.RLock()
+	jackettHost := currentSettings.JackettHost
+	jackettApiKey := currentSettings.JackettApiKey
+	settingsMutex.RUnlock()
+
+	if jackettHost == "" || jackettApiKey == "" {
+		http.Error(w, "Jackett host or API key not set", http.StatusBadRequest)
+		return
+	}
+
+	// Reuse the shared indexer client for Jackett
+	client := getIndexerClient()
+
+	// Jackett search endpoint - looking for movie torrents
+	searchURL := fmt.Sprintf("%s/api/v2.0/indexers/all/results?Query=%s&apikey=%s", jackettHost, url.QueryEscape(query), jackettApiKey)
+
+	req, err := http.NewRequest("GET", searchURL, nil)
+	if err != nil {
+		requestLogger(r.Context()).Printf("Error creating request: %v", err)
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		requestLogger(r.Context()).Printf("Error making request to Jackett: %v", err)
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to connect to Jackett: " + err.Error()})
+		return
+	}
+	defer resp.Body.Close()
+
+	// Read the response body
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		requestLogger(r.Context()).Printf("Error reading response: %v", err)
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to read Jackett response"})
+		return
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		respondWithJSON(w, resp.StatusCode, map[string]string{"error": fmt.Sprintf("Jackett returned status %d: %s", resp.StatusCode, string(body))})
+		return
+	}
+
+	var jacketResponse struct {
+		Results []map[string]interface{} `json:"Results"`
+	}
+
+	// Parse the JSON response and process the results
+	if err := json.Unmarshal(body, &jacketResponse); err != nil {
+		requestLogger(r.Context()).Printf("Error parsing JSON: %v", err)
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to parse Jackett response"})
+		return
+	}
+
+	// Process the results to make them more usable by the frontend
+	var processedResults []map[string]interface{}
+	for _, result := range jacketResponse.Results {
+		// Get title and download URL
+		title, hasTitle := result["Title"].(string)
+		downloadUrl, hasDownloadUrl := result["Link"].(string)
+
+		// Magnet URL might be present in some results
+		magnetUrl, hasMagnet := result["MagnetUri"].(string)
+
+		if !hasTitle || title == "" {
+			// Skip results without titles
+			continue
+		}
+
+		// We need at least one of download URL or magnet URL
+		if (!hasDownloadUrl || downloadUrl == "") && (!hasMagnet || magnetUrl == "") {
+			continue
+		}
+
+		// Create a simplified result object with just what we need
+		processedResult := map[string]interface{}{
+			"title": title,
+		}
+
+		// Prefer magnet URLs if available directly
+		if hasMagnet && magnetUrl != "" && strings.HasPrefix(magnetUrl, "magnet:") {
+			processedResult["magnetUrl"] = magnetUrl
+			processedResult["directMagnet"] = true
+		} else if hasDownloadUrl && downloadUrl != "" {
+			processedResult["downloadUrl"] = downloadUrl
+			processedResult["directMagnet"] = false
+		}
+
+		// Include optional fields if they exist
+		if size, ok := result["Size"].(float64); ok {
+			processedResult["size"] = formatSize(size)
+		}
+
+		if seeders, ok := result["Seeders"].(float64); ok {
+			processedResult["seeders"] = seeders
+		}
+
+		if leechers, ok := result["Peers"].(float64); ok {
+			processedResult["leechers"] = leechers
+		}
+
+		if indexer, ok := result["Tracker"].(string); ok {
+			processedResult["indexer"] = indexer
+		}
+
+		if publishDate, ok := result["PublishDate"].(string); ok {
+			processedResult["publishDate"] = publishDate
+		}
+
+		if category, ok := result["category"].(string); ok {
+			processedResult["category"] = category
+		}
+
+		processedResults = append(processedResults, processedResult)
+	}
+
+	// Best seeded first, like Prowlarr results
+	processedResults = filterByMinSeeders(sortBySeeders(processedResults), minSeeders)
+	respondWithJSON(w, http.StatusOK, searchResponse(query, "jackett", processedResults))
+}
+
+// Test Proxy Connection Handler
+func testProxyConnection(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var settings ProxySettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	proxyURL := settings.ProxyURL
+
+	if proxyURL == "" {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Proxy URL not set"})
+		return
+	}
+
+	// Parse the proxy URL
+	parsedProxyURL, err := url.Parse(proxyURL)
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid proxy URL: " + err.Error()})
+		return
+	}
+
+	responseBody, err := pingProxy(parsedProxyURL)
+	if err != nil {
+		requestLogger(r.Context()).Printf("Proxy test failed: %v", err)
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(responseBody)
+}
+
+// Fetch our public IP through the proxy and return the response body
+func pingProxy(proxyURL *url.URL) ([]byte, error) {
+	// Create a transport that uses the proxy the way the app would
+	transport := &http.Transport{}
+	switch proxyURL.Scheme {
+	case "socks5", "socks5h":
+		proxyDialer, err := createProxyDialer(proxyURL.String())
+		if err != nil {
+			return nil, fmt.Errorf("Could not create proxy dialer: %w", err)
+		}
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return proxyDialer.Dial(network, addr)
+		}
+	case "http", "https":
+		// Credentials in the URL are sent as Proxy-Authorization
+		transport.Proxy = http.ProxyURL(proxyURL)
+	default:
+		return nil, fmt.Errorf("Unsupported proxy scheme %q", proxyURL.Scheme)
+	}
+
+	// Create client with custom transport and timeout
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   10 * time.Second, // Adjust timeout as needed
+	}
+
+	testURL := "https://httpbin.org/ip"
+	req, err := http.NewRequest("GET", testURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Proxy connection failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.New("Failed to read proxy response")
+	}
+	return responseBody, nil
+}
+
+// Request YTS's first movie to check the configured server answers
+func pingYTS() error {
+	settingsMutex.RLock()
+	ytsServerURL := currentSettings.YTSServerURL
+	settingsMutex.RUnlock()
+
+	req, err := http.NewRequest("GET", ytsServerURL+"?limit=1", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
+
+	resp, err := createSelectiveProxyClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("Failed to connect to YTS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &upstreamStatusError{service: "YTS", statusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+// Outcome of checking one service for /api/v1/indexers/test
+type ServiceTestResult struct {
+	OK        bool   `json:"ok"`
+	LatencyMs int64  `json:"latencyMs"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Handler for POST /api/v1/indexers/test
+// Checks every enabled service concurrently with the saved settings
+func testAllIndexersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	if r.Method == "OPTIONS" {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	settingsMutex.RLock()
+	settings := currentSettings
+	settingsMutex.RUnlock()
+
+	checks := map[string]func() error{
+		"yts": pingYTS,
+	}
+	if settings.EnableProwlarr {
+		checks["prowlarr"] = func() error {
+			_, err := pingProwlarr(settings.ProwlarrHost, settings.ProwlarrApiKey)
+			return err
+		}
+	}
+	if settings.EnableJackett {
+		checks["jackett"] = func() error {
+			_, err := pingJackett(settings.JackettHost, settings.JackettApiKey)
+			return err
+		}
+	}
+	if settings.EnableProxy {
+		checks["proxy"] = func() error {
+			proxyURL, err := url.Parse(settings.ProxyURL)
+			if err != nil {
+				return fmt.Errorf("Invalid proxy URL: %w", err)
+			}
+			_, err = pingProxy(proxyURL)
+			return err
+		}
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]ServiceTestResult, len(checks))
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func() error) {
+			defer wg.Done()
+			start := time.Now()
+			err := check()
+			result := ServiceTestResult{OK: err == nil, LatencyMs: time.Since(start).Milliseconds()}
+			if err != nil {
+				result.Error = err.Error()
+			}
+			mu.Lock()
+			results[name] = result
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+
+	respondWithJSON(w, http.StatusOK, results)
+}
+
+// Helper function to save settings to file (assumes mutex is already locked)
+func saveSettingsToFile() error {
+	// Create the directory if it doesn't exist
+	if err := os.MkdirAll("config", 0755); err != nil {
+		return err
+	}
+
+	file, err := os.Create("config/settings.json")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(withoutEnvSettings(currentSettings)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Masked secrets start with this; only the last few characters are shown
+const maskedSecretPrefix = "********"
+
+// Hide all but the last 4 characters of a secret
+func maskSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	if len(secret) <= 4 {
+		return maskedSecretPrefix
+	}
+	return maskedSecretPrefix + secret[len(secret)-4:]
+}
+
+// Copy of s with API keys masked and proxy passwords removed
+func redactSettings(s Settings) Settings {
+	s.ProwlarrApiKey = maskSecret(s.ProwlarrApiKey)
+	s.JackettApiKey = maskSecret(s.JackettApiKey)
+	s.ProxyURL = redactProxyURL(s.ProxyURL)
+	s.FallbackProxyURLs = slices.Clone(s.FallbackProxyURLs)
+	for i, fallback := range s.FallbackProxyURLs {
+		s.FallbackProxyURLs[i] = redactProxyURL(fallback)
+	}
+	return s
+}
+
+// Redacted form of a proxy URL: the password is replaced
+func redactProxyURL(proxyURL string) string {
+	if u, err := url.Parse(proxyURL); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return proxyURL
+}
+
+// The current secret if value is its masked form, else value itself.
+// Lets clients send back what GET /settings returned without wiping keys.
+func unmaskSecret(value, current string) string {
+	if strings.HasPrefix(value, maskedSecretPrefix) {
+		return current
+	}
+	return value
+}
+
+// The current proxy URL if value is its redacted form, else value itself
+func unredactProxyURL(value, current string) string {
+	if value != current && value == redactProxyURL(current) {
+		return current
+	}
+	return value
+}
+
+// unredactProxyURL for a list: each entry that is the redacted form of a
+// current proxy gets that proxy back
+func unredactProxyURLs(values, current []string) []string {
+	restored := make([]string, len(values))
+	for i, value := range values {
+		restored[i] = value
+		for _, proxyURL := range current {
+			if unredacted := unredactProxyURL(value, proxyURL); unredacted != value {
+				restored[i] = unredacted
+				break
+			}
+		}
+	}
+	return restored
+}
+
+// Put back secrets that came in redacted, so importing a redacted export
+// keeps the keys that are already configured
+func restoreRedactedSecrets(s *Settings, current Settings) {
+	s.ProwlarrApiKey = unmaskSecret(s.ProwlarrApiKey, current.ProwlarrApiKey)
+	s.JackettApiKey = unmaskSecret(s.JackettApiKey, current.JackettApiKey)
+	s.ProxyURL = unredactProxyURL(s.ProxyURL, current.ProxyURL)
+	s.FallbackProxyURLs = unredactProxyURLs(s.FallbackProxyURLs, current.FallbackProxyURLs)
+}
+
+// Runtime configuration after defaults and environment overrides, with
+// values the server derives itself. Holds no secrets.
+type EffectiveConfig struct {
+	ListenAddress string `json:"listenAddress"`
+	TLS           bool   `json:"tls"`
+	StorageDir    string `json:"storageDir"`    // Session data goes in temp dirs here
+	StoragePrefix string `json:"storagePrefix"` // Name prefix of this instance's session dirs
+
+	PortRangeStart          int  `json:"portRangeStart"` // As configured
+	PortRangeEnd            int  `json:"portRangeEnd"`
+	EffectivePortRangeStart int  `json:"effectivePortRangeStart"` // After avoiding the ephemeral range
+	EffectivePortRangeEnd   int  `json:"effectivePortRangeEnd"`
+	AvoidEphemeralPorts     bool `json:"avoidEphemeralPorts"`
+
+	SessionIdleTimeoutSeconds     int `json:"sessionIdleTimeoutSeconds"`
+	SessionCleanupIntervalSeconds int `json:"sessionCleanupIntervalSeconds"`
+	MetadataTimeoutSeconds        int `json:"metadataTimeoutSeconds"`
+	StallTimeoutSeconds           int `json:"stallTimeoutSeconds"`
+	ReadHeaderTimeoutSeconds      int `json:"readHeaderTimeoutSeconds"`
+	IdleTimeoutSeconds            int `json:"idleTimeoutSeconds"`
+	APITimeoutSeconds             int `json:"apiTimeoutSeconds"`
+
+	MaxMagnetLength  int   `json:"maxMagnetLength"`
+	MaxUploadSize    int64 `json:"maxUploadSize"`
+	PrebufferSeconds int   `json:"prebufferSeconds"`
+	MinReadaheadMB   int   `json:"minReadaheadMB"`
+	MaxReadaheadMB   int   `json:"maxReadaheadMB"`
+
+	ProxyEnabled     bool `json:"proxyEnabled"`
+	ProwlarrEnabled  bool `json:"prowlarrEnabled"`
+	JackettEnabled   bool `json:"jackettEnabled"`
+	FavoritesEnabled bool `json:"favoritesEnabled"`
+	FFmpegAvailable  bool `json:"ffmpegAvailable"`
+	FFprobeAvailable bool `json:"ffprobeAvailable"`
+}
+
+// Handler for GET /api/v1/config. Unlike /api/v1/settings this is what the
+// server actually runs with, for support requests.
+func effectiveConfigHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == "OPTIONS" {
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	settingsMutex.RLock()
+	s := currentSettings
+	settingsMutex.RUnlock()
+
+	effectiveStart, effectiveEnd := listenPortRange()
+	_, ffmpegErr := exec.LookPath("ffmpeg")
+	_, ffprobeErr := exec.LookPath("ffprobe")
+
+	respondWithJSON(w, http.StatusOK, EffectiveConfig{
+		ListenAddress: serverAddr,
+		TLS:           s.TLSCertFile != "" && s.TLSKeyFile != "",
+		StorageDir:    os.TempDir(),
+		StoragePrefix: tempDirPrefix(),
+
+		PortRangeStart:          s.PortRangeStart,
+		PortRangeEnd:            s.PortRangeEnd,
+		EffectivePortRangeStart: effectiveStart,
+		EffectivePortRangeEnd:   effectiveEnd,
+		AvoidEphemeralPorts:     s.AvoidEphemeralPorts,
+
+		SessionIdleTimeoutSeconds:     int(sessionIdleTimeout.Seconds()),
+		SessionCleanupIntervalSeconds: int(sessionCleanupInterval.Seconds()),
+		MetadataTimeoutSeconds:        int(metadataTimeout.Seconds()),
+		StallTimeoutSeconds:           s.StallTimeoutSeconds,
+		ReadHeaderTimeoutSeconds:      s.ReadHeaderTimeoutSeconds,
+		IdleTimeoutSeconds:            s.IdleTimeoutSeconds,
+		APITimeoutSeconds:             s.APITimeoutSeconds,
+
+		MaxMagnetLength:  s.MaxMagnetLength,
+		MaxUploadSize:    s.MaxUploadSize,
+		PrebufferSeconds: s.PrebufferSeconds,
+		MinReadaheadMB:   s.MinReadaheadMB,
+		MaxReadaheadMB:   s.MaxReadaheadMB,
+
+		ProxyEnabled:     s.EnableProxy,
+		ProwlarrEnabled:  s.EnableProwlarr,
+		JackettEnabled:   s.EnableJackett,
+		FavoritesEnabled: !s.DisableFavorites,
+		FFmpegAvailable:  ffmpegErr == nil,
+		FFprobeAvailable: ffprobeErr == nil,
+	})
+}
+
+// Handler for GET /api/v1/settings/export
+// Returns the full settings for backup. API keys and proxy passwords are
+// redacted unless ?includeSecrets=true.
+func exportSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	if r.Method == "OPTIONS" {
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	includeSecrets := false
+	if param := r.URL.Query().Get("includeSecrets"); param != "" {
+		parsed, err := strconv.ParseBool(param)
+		if err != nil {
+			respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid includeSecrets value"})
+			return
+		}
+		includeSecrets = parsed
+	}
+	if includeSecrets && !isLocalRequest(r) {
+		respondWithJSON(w, http.StatusForbidden, map[string]string{"error": "Exporting secrets is only allowed from localhost"})
+		return
+	}
+
+	settingsMutex.RLock()
+	settings := currentSettings
+	settingsMutex.RUnlock()
+
+	if !includeSecrets {
+		settings = redactSettings(settings)
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="bitplay-settings.json"`)
+	respondWithJSON(w, http.StatusOK, settings)
+}
+
+// Handler for POST /api/v1/settings/import
+// Replaces all settings with the posted ones, as produced by
+// /settings/export, and re-applies the proxy. Redacted secrets keep their
+// current values and the instance ID is never changed.
+func importSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	if r.Method == "OPTIONS" {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var newSettings Settings
+	if err := json.NewDecoder(r.Body).Decode(&newSettings); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	// Reject values that would otherwise be silently replaced by defaults
+	if newSettings.PortRangeStart != 0 || newSettings.PortRangeEnd != 0 {
+		if err := validatePortRange(newSettings.PortRangeStart, newSettings.PortRangeEnd); err != nil {
+			respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+	}
+	if newSettings.DefaultSortBy != "" || newSettings.DefaultOrderBy != "" {
+		if err := validateYTSSort(newSettings.DefaultSortBy, newSettings.DefaultOrderBy); err != nil {
+			respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+	}
+	if newSettings.EnableProxy {
+		if _, err := url.Parse(newSettings.ProxyURL); err != nil || newSettings.ProxyURL == "" {
+			respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid proxy URL"})
+			return
+		}
+	}
+	applySettingsDefaults(&newSettings)
+
+	settingsMutex.Lock()
+	restoreRedactedSecrets(&newSettings, currentSettings)
+	newSettings.InstanceID = currentSettings.InstanceID
+	previous := currentSettings
+	currentSettings = newSettings
+	if err := saveSettingsToFile(); err != nil {
+		currentSettings = previous
+		settingsMutex.Unlock()
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to save settings: " + err.Error()})
+		return
+	}
+	settingsMutex.Unlock()
+
+	setGlobalProxy()
+	clearYTSCache()
+
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Settings imported successfully"})
+}
+
+// Proxy Settings Save Handler
+func saveProxySettingsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	if r.Method == "OPTIONS" {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var newSettings ProxySettings
+	if err := json.NewDecoder(r.Body).Decode(&newSettings); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	for _, fallback := range newSettings.FallbackProxyURLs {
+		if err := validateSOCKS5URL(fallback); err != nil {
+			respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid fallback proxy %q: %v", redactProxyURL(fallback), err)})
+			return
+		}
+	}
+
+	settingsMutex.Lock()
+	currentSettings.EnableProxy = newSettings.EnableProxy
+	currentSettings.ProxyURL = unredactProxyURL(newSettings.ProxyURL, currentSettings.ProxyURL)
+	if newSettings.FallbackProxyURLs != nil {
+		currentSettings.FallbackProxyURLs = unredactProxyURLs(newSettings.FallbackProxyURLs, currentSettings.FallbackProxyURLs)
+	}
+	err := saveSettingsToFile()
+	settingsMutex.Unlock()
 
-	req, err := http.NewRequest("GET", searchURL, nil)
 	if err != nil {
-		log.Printf("Error creating request: %v", err)
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to save settings: " + err.Error()})
+		return
+	}
+	println("Proxy settings saved successfully")
+
+	setGlobalProxy()
+
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Proxy settings saved successfully"})
+}
+
+// Handler for GET /api/v1/settings
+// API keys and proxy passwords are masked unless a local caller asks for
+// them with ?reveal=true.
+func getSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		// API keys are masked unless a local caller asks for them
+		reveal, _ := strconv.ParseBool(r.URL.Query().Get("reveal"))
+		if reveal && !isLocalRequest(r) {
+			respondWithJSON(w, http.StatusForbidden, map[string]string{"error": "Revealing secrets is only allowed from localhost"})
+			return
+		}
+
+		settingsMutex.RLock()
+		settings := currentSettings
+		settingsMutex.RUnlock()
+
+		if !reveal {
+			settings = redactSettings(settings)
+		}
+		respondWithJSON(w, http.StatusOK, settings)
+	} else {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Prowlarr Settings Save Handler
+func saveProwlarrSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	if r.Method == "OPTIONS" {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var newSettings ProwlarrSettings
+	if err := json.NewDecoder(r.Body).Decode(&newSettings); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	settingsMutex.Lock()
+	currentSettings.EnableProwlarr = newSettings.EnableProwlarr
+	currentSettings.ProwlarrHost = newSettings.ProwlarrHost
+	currentSettings.ProwlarrApiKey = unmaskSecret(newSettings.ProwlarrApiKey, currentSettings.ProwlarrApiKey)
+	err := saveSettingsToFile()
+	settingsMutex.Unlock()
+
+	if err != nil {
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to save settings: " + err.Error()})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Prowlarr settings saved successfully"})
+}
+
+// Jackett Settings Save Handler
+func saveJackettSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	if r.Method == "OPTIONS" {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var newSettings JackettSettings
+	if err := json.NewDecoder(r.Body).Decode(&newSettings); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	settingsMutex.Lock()
+	currentSettings.EnableJackett = newSettings.EnableJackett
+	currentSettings.JackettHost = newSettings.JackettHost
+	currentSettings.JackettApiKey = unmaskSecret(newSettings.JackettApiKey, currentSettings.JackettApiKey)
+	err := saveSettingsToFile()
+	settingsMutex.Unlock()
+
+	if err != nil {
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to save settings: " + err.Error()})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Jackett settings saved successfully"})
+}
+
+// YTS Settings Save Handler
+func saveYTSSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	if r.Method == "OPTIONS" {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var newSettings YTSSettings
+	if err := json.NewDecoder(r.Body).Decode(&newSettings); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	settingsMutex.Lock()
+	currentSettings.YTSServerURL = newSettings.YTSServerURL
+	err := saveSettingsToFile()
+	settingsMutex.Unlock()
+
+	if err != nil {
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to save settings: " + err.Error()})
+		return
+	}
+
+	// Pages cached from the old server no longer apply
+	clearYTSCache()
+
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "YTS server settings saved successfully"})
+}
+
+// Blocklist Settings Save Handler
+func saveBlocklistSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	if r.Method == "OPTIONS" {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var newSettings BlocklistSettings
+	if err := json.NewDecoder(r.Body).Decode(&newSettings); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	// Normalize infohashes so lookups are case-insensitive
+	var infohashes []string
+	for _, infoHash := range newSettings.BlockedInfohashes {
+		if infoHash = strings.ToLower(strings.TrimSpace(infoHash)); infoHash != "" {
+			infohashes = append(infohashes, infoHash)
+		}
+	}
+
+	settingsMutex.Lock()
+	currentSettings.BlockedInfohashes = infohashes
+	currentSettings.BlockedTrackers = newSettings.BlockedTrackers
+	defer settingsMutex.Unlock()
+
+	if err := saveSettingsToFile(); err != nil {
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to save settings: " + err.Error()})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Blocklist settings saved successfully"})
+}
+
+// Answer 501 when the favorites database wasn't opened.
+// Returns true if the caller should stop.
+func rejectIfFavoritesDisabled(w http.ResponseWriter) bool {
+	if db != nil {
+		return false
+	}
+	respondWithJSON(w, http.StatusNotImplemented, map[string]string{"error": "Favorites are disabled on this server"})
+	return true
+}
+
+// Port Range Settings Save Handler
+// Applies to sessions started after the change.
+func savePortRangeSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	if r.Method == "OPTIONS" {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var newSettings PortRangeSettings
+	if err := json.NewDecoder(r.Body).Decode(&newSettings); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	if err := validatePortRange(newSettings.PortRangeStart, newSettings.PortRangeEnd); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	settingsMutex.Lock()
+	currentSettings.PortRangeStart = newSettings.PortRangeStart
+	currentSettings.PortRangeEnd = newSettings.PortRangeEnd
+	currentSettings.AvoidEphemeralPorts = newSettings.AvoidEphemeralPorts
+	defer settingsMutex.Unlock()
+
+	if err := saveSettingsToFile(); err != nil {
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to save settings: " + err.Error()})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Port range settings saved successfully"})
+}
+
+func saveStreamExtensionSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	if r.Method == "OPTIONS" {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var newSettings StreamExtensionSettings
+	if err := json.NewDecoder(r.Body).Decode(&newSettings); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	settingsMutex.Lock()
+	currentSettings.AllowedStreamExtensions = normalizeExtensions(newSettings.AllowedStreamExtensions)
+	defer settingsMutex.Unlock()
+
+	if err := saveSettingsToFile(); err != nil {
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to save settings: " + err.Error()})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Stream extension settings saved successfully"})
+}
+
+func saveYTSSortSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	if r.Method == "OPTIONS" {
 		return
 	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("Error making request to Jackett: %v", err)
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to connect to Jackett: " + err.Error()})
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	defer resp.Body.Close()
 
-	// Read the response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Error reading response: %v", err)
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to read Jackett response"})
+	var newSettings YTSSortSettings
+	if err := json.NewDecoder(r.Body).Decode(&newSettings); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
 		return
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		respondWithJSON(w, resp.StatusCode, map[string]string{"error": fmt.Sprintf("Jackett returned status %d: %s", resp.StatusCode, string(body))})
+	if err := validateYTSSort(newSettings.DefaultSortBy, newSettings.DefaultOrderBy); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
 		return
 	}
 
-	var jacketResponse struct {
-		Results []map[string]interface{} `json:"Results"`
+	settingsMutex.Lock()
+	currentSettings.DefaultSortBy = newSettings.DefaultSortBy
+	currentSettings.DefaultOrderBy = newSettings.DefaultOrderBy
+	defer settingsMutex.Unlock()
+
+	if err := saveSettingsToFile(); err != nil {
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to save settings: " + err.Error()})
+		return
 	}
 
-	// Parse the JSON response and process the results
-	if err := json.Unmarshal(body, &jacketResponse); err != nil {
-		log.Printf("Error parsing JSON: %v", err)
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to parse Jackett response"})
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "YTS sort settings saved successfully"})
+}
+
+// Favorites Handlers
+func favoritesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
 		return
 	}
 
-	// Process the results to make them more usable by the frontend
-	var processedResults []map[string]interface{}
-	for _, result := range jacketResponse.Results {
-		// Get title and download URL
-		title, hasTitle := result["Title"].(string)
-		downloadUrl, hasDownloadUrl := result["Link"].(string)
+	if rejectIfFavoritesDisabled(w) {
+		return
+	}
 
-		// Magnet URL might be present in some results
-		magnetUrl, hasMagnet := result["MagnetUri"].(string)
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
-		if !hasTitle || title == "" {
-			// Skip results without titles
-			continue
-		}
+	rows, err := db.Query("SELECT " + favoriteColumns + " FROM favorites ORDER BY created_at DESC")
+	if err != nil {
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to fetch favorites"})
+		return
+	}
+	defer rows.Close()
 
-		// We need at least one of download URL or magnet URL
-		if (!hasDownloadUrl || downloadUrl == "") && (!hasMagnet || magnetUrl == "") {
+	// Stream rows straight into the response instead of building the whole
+	// list in memory first
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	favorites := newJSONArrayWriter(w)
+	for rows.Next() {
+		favorite, err := scanFavorite(rows)
+		if err != nil {
 			continue
 		}
 
-		// Create a simplified result object with just what we need
-		processedResult := map[string]interface{}{
-			"title": title,
-		}
-
-		// Prefer magnet URLs if available directly
-		if hasMagnet && magnetUrl != "" && strings.HasPrefix(magnetUrl, "magnet:") {
-			processedResult["magnetUrl"] = magnetUrl
-			processedResult["directMagnet"] = true
-		} else if hasDownloadUrl && downloadUrl != "" {
-			processedResult["downloadUrl"] = downloadUrl
-			processedResult["directMagnet"] = false
+		if err := favorites.Write(favorite); err != nil {
+			// The client went away; nothing more can be sent
+			requestLogger(r.Context()).Printf("Error streaming favorites: %v", err)
+			return
 		}
+	}
+	if err := rows.Err(); err != nil {
+		// Too late for an error status, so end with a valid but partial list
+		requestLogger(r.Context()).Printf("Error reading favorites: %v", err)
+	}
 
-		// Include optional fields if they exist
-		if size, ok := result["Size"].(float64); ok {
-			processedResult["size"] = formatSize(size)
-		}
+	// An empty table still produces []
+	favorites.Close()
+}
 
-		if seeders, ok := result["Seeders"].(float64); ok {
-			processedResult["seeders"] = seeders
-		}
+// Columns scanned by scanFavorite, in order
+const favoriteColumns = "movie_id, title, year, rating, runtime, genres, summary, cover_image, torrents, created_at"
 
-		if leechers, ok := result["Peers"].(float64); ok {
-			processedResult["leechers"] = leechers
-		}
+// Turn a favorites row into the movie shape the frontend uses
+func scanFavorite(rows *sql.Rows) (map[string]interface{}, error) {
+	var movieID int
+	var title, genres, summary, coverImage, torrents, createdAt string
+	var year, runtime int
+	var rating float64
 
-		if indexer, ok := result["Tracker"].(string); ok {
-			processedResult["indexer"] = indexer
-		}
+	err := rows.Scan(&movieID, &title, &year, &rating, &runtime, &genres, &summary, &coverImage, &torrents, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse torrents JSON
+	var torrentsData []interface{}
+	json.Unmarshal([]byte(torrents), &torrentsData)
+
+	// Parse genres
+	var genresData []string
+	json.Unmarshal([]byte(genres), &genresData)
+
+	return map[string]interface{}{
+		"id":                 movieID,
+		"title":              title,
+		"year":               year,
+		"rating":             rating,
+		"runtime":            runtime,
+		"genres":             genresData,
+		"summary":            summary,
+		"medium_cover_image": coverImage,
+		"torrents":           torrentsData,
+	}, nil
+}
 
-		if publishDate, ok := result["PublishDate"].(string); ok {
-			processedResult["publishDate"] = publishDate
-		}
+// Writes a JSON array one element at a time
+type jsonArrayWriter struct {
+	w       io.Writer
+	encoder *json.Encoder
+	count   int
+}
 
-		if category, ok := result["category"].(string); ok {
-			processedResult["category"] = category
-		}
+func newJSONArrayWriter(w io.Writer) *jsonArrayWriter {
+	return &jsonArrayWriter{w: w, encoder: json.NewEncoder(w)}
+}
 
-		processedResults = append(processedResults, processedResult)
+// Append one element, opening the array on the first call
+func (a *jsonArrayWriter) Write(v interface{}) error {
+	separator := ","
+	if a.count == 0 {
+		separator = "["
+	}
+	if _, err := io.WriteString(a.w, separator); err != nil {
+		return err
 	}
+	a.count++
+	return a.encoder.Encode(v)
+}
 
-	respondWithJSON(w, http.StatusOK, processedResults)
+// Finish the array
+func (a *jsonArrayWriter) Close() error {
+	closing := "]\n"
+	if a.count == 0 {
+		closing = "[]\n"
+	}
+	_, err := io.WriteString(a.w, closing)
+	return err
 }
 
-// Test Proxy Connection Handler
-func testProxyConnection(w http.ResponseWriter, r *http.Request) {
-	// Add CORS headers
+func addFavoriteHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 
-	// Handle preflight requests
 	if r.Method == "OPTIONS" {
 		return
 	}
 
+	if rejectIfFavoritesDisabled(w) {
+		return
+	}
+
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	var settings ProxySettings
-	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+	var movie map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&movie); err != nil {
 		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
 		return
 	}
 
-	proxyURL := settings.ProxyURL
+	// Extract and marshal arrays
+	genresJSON, _ := json.Marshal(movie["genres"])
+	torrentsJSON, _ := json.Marshal(movie["torrents"])
 
-	if proxyURL == "" {
-		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Proxy URL not set"})
-		return
-	}
+	_, err := execWithRetry(`INSERT OR REPLACE INTO favorites
+		(movie_id, title, year, rating, runtime, genres, summary, cover_image, torrents)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		movie["movie_id"], movie["title"], movie["year"], movie["rating"], movie["runtime"],
+		string(genresJSON), movie["summary"], movie["cover_image"], string(torrentsJSON))
 
-	// Parse the proxy URL
-	parsedProxyURL, err := url.Parse(proxyURL)
 	if err != nil {
-		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid proxy URL: " + err.Error()})
+		requestLogger(r.Context()).Printf("Error adding favorite: %v", err)
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to add favorite"})
 		return
 	}
 
-	// Create a transport that uses the proxy
-	transport := &http.Transport{
-		Proxy: http.ProxyURL(parsedProxyURL),
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Added to favorites"})
+}
+
+// Handler for POST /api/v1/favorites/import
+// Takes a JSON array of movies, either as exported by GET /api/v1/favorites
+// or in the shape /favorites/add accepts, and adds or updates them all in
+// one transaction. Entries without an ID or title are skipped; any
+// database error rolls the whole import back.
+func importFavoritesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		return
 	}
 
-	// Create client with custom transport and timeout
-	client := &http.Client{
-		Transport: transport,
-		Timeout:   10 * time.Second, // Adjust timeout as needed
+	if rejectIfFavoritesDisabled(w) {
+		return
 	}
 
-	testURL := "https://httpbin.org/ip"
-	req, err := http.NewRequest("GET", testURL, nil)
-	if err != nil {
-		log.Printf("Error creating request: %v", err)
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("Error making request through proxy: %v", err)
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Proxy connection failed: " + err.Error()})
+	r.Body = http.MaxBytesReader(w, r.Body, maxFavoritesImportSize)
+	var movies []map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&movies); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
 		return
 	}
-	defer resp.Body.Close()
 
-	responseBody, err := io.ReadAll(resp.Body)
+	var result favoritesImportResult
+	var err error
+	for attempt := 1; attempt <= favoritesWriteRetries; attempt++ {
+		result, err = importFavorites(movies)
+		if !isSQLiteBusy(err) {
+			break
+		}
+		requestLogger(r.Context()).Printf("Database busy, retrying import (attempt %d/%d)", attempt, favoritesWriteRetries)
+		time.Sleep(time.Duration(attempt) * favoritesRetryDelay)
+	}
 	if err != nil {
-		log.Printf("Error reading response: %v", err)
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to read proxy response"})
+		requestLogger(r.Context()).Printf("Error importing favorites: %v", err)
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to import favorites"})
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(responseBody)
+	respondWithJSON(w, http.StatusOK, result)
 }
 
-// Helper function to save settings to file (assumes mutex is already locked)
-func saveSettingsToFile() error {
-	// Create the directory if it doesn't exist
-	if err := os.MkdirAll("config", 0755); err != nil {
-		log.Fatalf("Failed to create config directory: %v", err)
-	}
+// Outcome of a favorites import
+type favoritesImportResult struct {
+	Inserted int `json:"inserted"`
+	Updated  int `json:"updated"`
+	Skipped  int `json:"skipped"`
+}
 
-	file, err := os.Create("config/settings.json")
+// Upsert movies in a single transaction, reusing prepared statements
+func importFavorites(movies []map[string]interface{}) (favoritesImportResult, error) {
+	var result favoritesImportResult
+
+	tx, err := db.Begin()
 	if err != nil {
-		return err
+		return result, err
 	}
-	defer file.Close()
+	defer tx.Rollback()
 
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	if err := encoder.Encode(currentSettings); err != nil {
-		return err
+	existsStmt, err := tx.Prepare("SELECT 1 FROM favorites WHERE movie_id = ?")
+	if err != nil {
+		return result, err
 	}
+	defer existsStmt.Close()
 
-	return nil
-}
-
-// Proxy Settings Save Handler
-func saveProxySettingsHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-	if r.Method == "OPTIONS" {
-		return
-	}
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+	upsertStmt, err := tx.Prepare(`INSERT INTO favorites
+		(movie_id, title, year, rating, runtime, genres, summary, cover_image, torrents)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(movie_id) DO UPDATE SET
+			title = excluded.title, year = excluded.year, rating = excluded.rating,
+			runtime = excluded.runtime, genres = excluded.genres, summary = excluded.summary,
+			cover_image = excluded.cover_image, torrents = excluded.torrents`)
+	if err != nil {
+		return result, err
 	}
+	defer upsertStmt.Close()
 
-	var newSettings ProxySettings
-	if err := json.NewDecoder(r.Body).Decode(&newSettings); err != nil {
-		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
-		return
-	}
+	for _, movie := range movies {
+		// Exports use the frontend's field names, /favorites/add the table's
+		movieID, ok := movie["movie_id"].(float64)
+		if !ok {
+			movieID, ok = movie["id"].(float64)
+		}
+		title, _ := movie["title"].(string)
+		if !ok || movieID <= 0 || title == "" {
+			result.Skipped++
+			continue
+		}
+		coverImage := movie["cover_image"]
+		if coverImage == nil {
+			coverImage = movie["medium_cover_image"]
+		}
 
-	settingsMutex.RLock()
-	currentSettings.EnableProxy = newSettings.EnableProxy
-	currentSettings.ProxyURL = newSettings.ProxyURL
-	defer settingsMutex.RUnlock()
+		var exists int
+		err := existsStmt.QueryRow(int64(movieID)).Scan(&exists)
+		if err != nil && err != sql.ErrNoRows {
+			return favoritesImportResult{}, err
+		}
 
-	if err := saveSettingsToFile(); err != nil {
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to save settings: " + err.Error()})
-		return
-	}
-	println("Proxy settings saved successfully")
+		genresJSON, _ := json.Marshal(movie["genres"])
+		torrentsJSON, _ := json.Marshal(movie["torrents"])
+		_, err = upsertStmt.Exec(int64(movieID), title, movie["year"], movie["rating"], movie["runtime"],
+			string(genresJSON), movie["summary"], coverImage, string(torrentsJSON))
+		if err != nil {
+			return favoritesImportResult{}, fmt.Errorf("movie %d: %w", int64(movieID), err)
+		}
 
-	setGlobalProxy()
+		if exists == 1 {
+			result.Updated++
+		} else {
+			result.Inserted++
+		}
+	}
 
-	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Proxy settings saved successfully"})
+	if err := tx.Commit(); err != nil {
+		return favoritesImportResult{}, err
+	}
+	return result, nil
 }
 
-// Prowlarr Settings Save Handler
-func saveProwlarrSettingsHandler(w http.ResponseWriter, r *http.Request) {
+func removeFavoriteHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
 	if r.Method == "OPTIONS" {
 		return
 	}
-	if r.Method != http.MethodPost {
+
+	if rejectIfFavoritesDisabled(w) {
+		return
+	}
+
+	if r.Method != http.MethodDelete {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	var newSettings ProwlarrSettings
-	if err := json.NewDecoder(r.Body).Decode(&newSettings); err != nil {
-		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	// Extract movie ID from URL path
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) < 6 {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Movie ID required"})
 		return
 	}
 
-	settingsMutex.RLock()
-	currentSettings.EnableProwlarr = newSettings.EnableProwlarr
-	currentSettings.ProwlarrHost = newSettings.ProwlarrHost
-	currentSettings.ProwlarrApiKey = newSettings.ProwlarrApiKey
-	defer settingsMutex.RUnlock()
+	movieID := parts[5]
 
-	if err := saveSettingsToFile(); err != nil {
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to save settings: " + err.Error()})
+	// Convert string to int to match database INTEGER type
+	movieIDInt, err := strconv.Atoi(movieID)
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid movie ID"})
+		return
+	}
+
+	_, err = execWithRetry("DELETE FROM favorites WHERE movie_id = ?", movieIDInt)
+	if err != nil {
+		requestLogger(r.Context()).Printf("Error removing favorite: %v", err)
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to remove favorite"})
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Prowlarr settings saved successfully"})
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Removed from favorites"})
 }
 
-// Jackett Settings Save Handler
-func saveJackettSettingsHandler(w http.ResponseWriter, r *http.Request) {
+// Handler for POST /api/v1/favorites/status
+// Takes {"movieIds": [...]} and answers which of them are favorites in one
+// query, so a grid of movie cards doesn't need a request per card.
+func favoritesStatusHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
 	if r.Method == "OPTIONS" {
 		return
 	}
+
+	if rejectIfFavoritesDisabled(w) {
+		return
+	}
+
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	var newSettings JackettSettings
-	if err := json.NewDecoder(r.Body).Decode(&newSettings); err != nil {
+	var request struct {
+		MovieIDs []int `json:"movieIds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
 		return
 	}
+	if len(request.MovieIDs) > maxFavoritesStatusIDs {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{
+			"error": fmt.Sprintf("At most %d movie IDs per request", maxFavoritesStatusIDs),
+		})
+		return
+	}
 
-	settingsMutex.RLock()
-	currentSettings.EnableJackett = newSettings.EnableJackett
-	currentSettings.JackettHost = newSettings.JackettHost
-	currentSettings.JackettApiKey = newSettings.JackettApiKey
-	defer settingsMutex.RUnlock()
+	// Every requested ID is in the answer, false unless the query finds it
+	favorited := make(map[int]bool, len(request.MovieIDs))
+	args := make([]interface{}, len(request.MovieIDs))
+	for i, id := range request.MovieIDs {
+		favorited[id] = false
+		args[i] = id
+	}
 
-	if err := saveSettingsToFile(); err != nil {
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to save settings: " + err.Error()})
-		return
+	if len(args) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(args)), ",")
+		rows, err := db.Query("SELECT movie_id FROM favorites WHERE movie_id IN ("+placeholders+")", args...)
+		if err != nil {
+			requestLogger(r.Context()).Printf("Error checking favorites: %v", err)
+			respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to check favorites"})
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err == nil {
+				favorited[id] = true
+			}
+		}
+		if err := rows.Err(); err != nil {
+			requestLogger(r.Context()).Printf("Error checking favorites: %v", err)
+			respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to check favorites"})
+			return
+		}
 	}
 
-	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Jackett settings saved successfully"})
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"favorited": favorited,
+	})
 }
 
-// YTS Settings Save Handler
-func saveYTSSettingsHandler(w http.ResponseWriter, r *http.Request) {
+// Handler for GET /api/v1/discover?q=<query>
+// Searches favorites and YTS together. Every result is tagged with the
+// sources it came from ("favorites", "yts" or both) and whether it is a
+// favorite, so the UI can badge library items inline.
+func discoverHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
 	if r.Method == "OPTIONS" {
 		return
 	}
-	if r.Method != http.MethodPost {
+
+	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	var newSettings YTSSettings
-	if err := json.NewDecoder(r.Body).Decode(&newSettings); err != nil {
-		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "No search query provided"})
 		return
 	}
 
-	settingsMutex.RLock()
-	currentSettings.YTSServerURL = newSettings.YTSServerURL
-	defer settingsMutex.RUnlock()
+	// Favorites are optional; without them discover is a plain YTS search
+	var favorites []map[string]interface{}
+	if db != nil {
+		var err error
+		favorites, err = searchFavorites(query)
+		if err != nil {
+			requestLogger(r.Context()).Printf("Error searching favorites: %v", err)
+			respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to search favorites"})
+			return
+		}
+	}
 
-	if err := saveSettingsToFile(); err != nil {
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to save settings: " + err.Error()})
-		return
+	var ytsMovies []interface{}
+	ytsError := ""
+	apiResp, err := fetchYTSList(createSelectiveProxyClient(), ytsListQuery{
+		Page:    1,
+		Limit:   defaultYTSLimit,
+		Query:   query,
+		SortBy:  "date_added",
+		OrderBy: "desc",
+	})
+	if err != nil {
+		// Still show the library matches when YTS is unreachable
+		requestLogger(r.Context()).Printf("Error searching YTS: %v", err)
+		ytsError = err.Error()
+	} else if data, ok := apiResp["data"].(map[string]interface{}); ok {
+		ytsMovies, _ = data["movies"].([]interface{})
 	}
 
-	respondWithJSON(w, http.StatusOK, map[string]string{"message": "YTS server settings saved successfully"})
+	results := mergeDiscoverResults(favorites, ytsMovies)
+
+	response := map[string]interface{}{
+		"query":   query,
+		"count":   len(results),
+		"results": results,
+	}
+	if ytsError != "" {
+		response["ytsError"] = ytsError
+	}
+	respondWithJSON(w, http.StatusOK, response)
 }
 
-// Favorites Handlers
-func favoritesHandler(w http.ResponseWriter, r *http.Request) {
+// Favorites whose title contains the query, case-insensitively
+func searchFavorites(query string) ([]map[string]interface{}, error) {
+	// Escape LIKE wildcards so they match literally
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query) + "%"
+	rows, err := db.Query("SELECT "+favoriteColumns+` FROM favorites WHERE title LIKE ? ESCAPE '\' ORDER BY created_at DESC`, pattern)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var favorites []map[string]interface{}
+	for rows.Next() {
+		if favorite, err := scanFavorite(rows); err == nil {
+			favorites = append(favorites, favorite)
+		}
+	}
+	return favorites, rows.Err()
+}
+
+// Combine favorites with YTS results, favorites first. A YTS movie matching
+// a favorite by id, or by title and year, is merged into it: the fresh YTS
+// data is used and both sources are listed.
+func mergeDiscoverResults(favorites []map[string]interface{}, ytsMovies []interface{}) []map[string]interface{} {
+	results := []map[string]interface{}{}
+	indexByKey := make(map[string]int)
+
+	for _, favorite := range favorites {
+		favorite["sources"] = []string{"favorites"}
+		favorite["inFavorites"] = true
+		for _, key := range discoverKeys(favorite) {
+			indexByKey[key] = len(results)
+		}
+		results = append(results, favorite)
+	}
+
+	for _, item := range ytsMovies {
+		movie, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		existing := -1
+		for _, key := range discoverKeys(movie) {
+			if i, ok := indexByKey[key]; ok {
+				existing = i
+				break
+			}
+		}
+
+		if existing == -1 {
+			movie["sources"] = []string{"yts"}
+			movie["inFavorites"] = false
+			results = append(results, movie)
+			continue
+		}
+
+		// Same movie from both sources; YTS has the current torrents
+		movie["sources"] = []string{"favorites", "yts"}
+		movie["inFavorites"] = true
+		results[existing] = movie
+	}
+
+	return results
+}
+
+// Keys a movie is deduplicated on: its YTS id, and its title with year
+func discoverKeys(movie map[string]interface{}) []string {
+	var keys []string
+	switch id := movie["id"].(type) {
+	case int:
+		keys = append(keys, fmt.Sprintf("id:%d", id))
+	case float64:
+		keys = append(keys, fmt.Sprintf("id:%d", int(id)))
+	}
+	if title, ok := movie["title"].(string); ok && title != "" {
+		year := 0
+		switch y := movie["year"].(type) {
+		case int:
+			year = y
+		case float64:
+			year = int(y)
+		}
+		keys = append(keys, fmt.Sprintf("title:%s:%d", strings.ToLower(title), year))
+	}
+	return keys
+}
+
+// Handler for POST /api/v1/favorites/prune
+// Reports favorites whose movies no longer exist on YTS, and deletes them
+// when called with ?delete=true
+func pruneFavoritesHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 
@@ -1499,174 +6545,414 @@ func favoritesHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if r.Method != http.MethodGet {
+	if rejectIfFavoritesDisabled(w) {
+		return
+	}
+
+	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	rows, err := db.Query(`SELECT movie_id, title, year, rating, runtime, genres, summary, cover_image, torrents, created_at
-		FROM favorites ORDER BY created_at DESC`)
+	deleteStale := r.URL.Query().Get("delete") == "true"
+
+	rows, err := db.Query("SELECT movie_id FROM favorites")
 	if err != nil {
 		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to fetch favorites"})
 		return
 	}
-	defer rows.Close()
-
-	var favorites []map[string]interface{}
+	var movieIDs []int
 	for rows.Next() {
 		var movieID int
-		var title, genres, summary, coverImage, torrents, createdAt string
-		var year, runtime int
-		var rating float64
+		if err := rows.Scan(&movieID); err == nil {
+			movieIDs = append(movieIDs, movieID)
+		}
+	}
+	rows.Close()
+
+	client := createSelectiveProxyClient()
+	detailsURL := ytsMovieDetailsURL()
+
+	stale := []int{}
+	unchecked := []int{}
+	for i, movieID := range movieIDs {
+		if i > 0 {
+			select {
+			case <-time.After(favoritesPruneInterval):
+			case <-r.Context().Done():
+				return
+			}
+		}
 
-		err := rows.Scan(&movieID, &title, &year, &rating, &runtime, &genres, &summary, &coverImage, &torrents, &createdAt)
+		exists, err := ytsMovieExists(client, detailsURL, movieID)
 		if err != nil {
+			// Don't call a movie stale just because YTS was unreachable
+			requestLogger(r.Context()).Printf("Failed to check favorite %d: %v", movieID, err)
+			unchecked = append(unchecked, movieID)
 			continue
 		}
+		if !exists {
+			stale = append(stale, movieID)
+		}
+	}
 
-		// Parse torrents JSON
-		var torrentsData []interface{}
-		json.Unmarshal([]byte(torrents), &torrentsData)
-
-		// Parse genres
-		var genresData []string
-		json.Unmarshal([]byte(genres), &genresData)
-
-		favorites = append(favorites, map[string]interface{}{
-			"id":                  movieID,
-			"title":               title,
-			"year":                year,
-			"rating":              rating,
-			"runtime":             runtime,
-			"genres":              genresData,
-			"summary":             summary,
-			"medium_cover_image":  coverImage,
-			"torrents":            torrentsData,
-		})
+	if deleteStale {
+		for _, movieID := range stale {
+			if _, err := execWithRetry("DELETE FROM favorites WHERE movie_id = ?", movieID); err != nil {
+				requestLogger(r.Context()).Printf("Error removing stale favorite %d: %v", movieID, err)
+			}
+		}
 	}
 
-	// Return empty array if no favorites
-	if favorites == nil {
-		favorites = []map[string]interface{}{}
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"stale":     stale,
+		"unchecked": unchecked,
+		"deleted":   deleteStale,
+	})
+}
+
+// The YTS movie_details endpoint next to the configured list_movies URL
+func ytsMovieDetailsURL() string {
+	settingsMutex.RLock()
+	ytsServerURL := currentSettings.YTSServerURL
+	settingsMutex.RUnlock()
+
+	if ytsServerURL == "" {
+		ytsServerURL = "https://yts.mx/api/v2/list_movies.json"
 	}
+	return strings.Replace(ytsServerURL, "list_movies.json", "movie_details.json", 1)
+}
 
-	respondWithJSON(w, http.StatusOK, favorites)
+// The parts of a YTS movie_details response we use
+type ytsMovieDetails struct {
+	ID       int    `json:"id"`
+	Title    string `json:"title"`
+	Year     int    `json:"year"`
+	Torrents []struct {
+		Hash      string `json:"hash"`
+		Quality   string `json:"quality"`
+		Type      string `json:"type"`
+		Size      string `json:"size"`
+		SizeBytes int64  `json:"size_bytes"`
+	} `json:"torrents"`
 }
 
-func addFavoriteHandler(w http.ResponseWriter, r *http.Request) {
+// Fetch a movie from YTS movie_details. Returns nil when YTS doesn't know
+// the movie; it answers unknown IDs with an empty movie (id 0) rather than
+// an error status.
+func fetchYTSMovieDetails(client *http.Client, detailsURL string, movieID int) (*ytsMovieDetails, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s?movie_id=%d", detailsURL, movieID), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var apiResp struct {
+		Data struct {
+			Movie ytsMovieDetails `json:"movie"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+		return nil, err
+	}
+	if apiResp.Data.Movie.ID == 0 {
+		return nil, nil
+	}
+	return &apiResp.Data.Movie, nil
+}
+
+// Whether YTS still knows the movie
+func ytsMovieExists(client *http.Client, detailsURL string, movieID int) (bool, error) {
+	movie, err := fetchYTSMovieDetails(client, detailsURL, movieID)
+	return movie != nil, err
+}
+
+// Handler for GET /api/v1/yts/magnet?movie_id=<id>&quality=<quality>[&type=<type>]
+// Returns just the magnet for one of a movie's torrents, for direct links.
+func ytsMagnetHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
 	if r.Method == "OPTIONS" {
 		return
 	}
-
-	if r.Method != http.MethodPost {
+	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	var movie map[string]interface{}
-	if err := json.NewDecoder(r.Body).Decode(&movie); err != nil {
-		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	movieID, err := strconv.Atoi(r.URL.Query().Get("movie_id"))
+	if err != nil || movieID <= 0 {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid movie_id"})
 		return
 	}
+	quality := r.URL.Query().Get("quality")
+	if quality == "" {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Missing quality"})
+		return
+	}
+	torrentType := r.URL.Query().Get("type") // e.g. bluray or web; any if empty
 
-	// Extract and marshal arrays
-	genresJSON, _ := json.Marshal(movie["genres"])
-	torrentsJSON, _ := json.Marshal(movie["torrents"])
-
-	_, err := db.Exec(`INSERT OR REPLACE INTO favorites
-		(movie_id, title, year, rating, runtime, genres, summary, cover_image, torrents)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		movie["movie_id"], movie["title"], movie["year"], movie["rating"], movie["runtime"],
-		string(genresJSON), movie["summary"], movie["cover_image"], string(torrentsJSON))
-
+	movie, err := fetchYTSMovieDetails(createSelectiveProxyClient(), ytsMovieDetailsURL(), movieID)
 	if err != nil {
-		log.Printf("Error adding favorite: %v", err)
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to add favorite"})
+		respondWithJSON(w, http.StatusBadGateway, map[string]string{"error": "Failed to fetch movie: " + err.Error()})
+		return
+	}
+	if movie == nil {
+		respondWithJSON(w, http.StatusNotFound, map[string]string{"error": "Movie not found"})
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Added to favorites"})
+	var available []string
+	for _, t := range movie.Torrents {
+		available = append(available, t.Quality)
+		if !strings.EqualFold(t.Quality, quality) || (torrentType != "" && !strings.EqualFold(t.Type, torrentType)) {
+			continue
+		}
+		respondWithJSON(w, http.StatusOK, map[string]interface{}{
+			"movieId":   movie.ID,
+			"title":     movie.Title,
+			"year":      movie.Year,
+			"quality":   t.Quality,
+			"type":      t.Type,
+			"size":      t.Size,
+			"sizeBytes": t.SizeBytes,
+			"hash":      t.Hash,
+			"magnetUrl": buildYTSMagnet(t.Hash, movie.Title+" "+t.Quality),
+		})
+		return
+	}
+
+	respondWithJSON(w, http.StatusNotFound, map[string]interface{}{
+		"error":     "Quality not available",
+		"available": available,
+	})
 }
 
-func removeFavoriteHandler(w http.ResponseWriter, r *http.Request) {
+// Fetch YTS Movies Handler - Uses YTS API directly
+func fetchYTSMovies(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 
+	// Handle preflight requests
 	if r.Method == "OPTIONS" {
 		return
 	}
 
-	if r.Method != http.MethodDelete {
+	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Extract movie ID from URL path
-	parts := strings.Split(r.URL.Path, "/")
-	if len(parts) < 6 {
-		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Movie ID required"})
+	// Get query parameters
+	requestedPage := r.URL.Query().Get("page")
+	if requestedPage == "" {
+		requestedPage = "1"
+	}
+	pageNum, _ := strconv.Atoi(requestedPage)
+
+	// Page size, clamped to what YTS allows
+	limit := defaultYTSLimit
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		if parsed, err := strconv.Atoi(limitParam); err == nil {
+			limit = max(1, min(parsed, maxYTSLimit))
+		}
+	}
+
+	searchQuery := r.URL.Query().Get("query")
+	sortBy := r.URL.Query().Get("sort_by")
+	orderBy := r.URL.Query().Get("order_by")
+
+	// Fall back to the configured browse order
+	settingsMutex.RLock()
+	if sortBy == "" {
+		sortBy = currentSettings.DefaultSortBy
+	}
+	if orderBy == "" {
+		orderBy = currentSettings.DefaultOrderBy
+	}
+	settingsMutex.RUnlock()
+
+	// Only known values reach the upstream URL and the cache keys
+	if err := validateYTSSort(sortBy, orderBy); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
 		return
 	}
 
-	movieID := parts[5]
+	// Optionally narrow the listing to one genre
+	genre := ""
+	if param := r.URL.Query().Get("genre"); param != "" {
+		var ok bool
+		if genre, ok = lookupYTSGenre(param); !ok {
+			respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Unknown genre"})
+			return
+		}
+	}
 
-	// Convert string to int to match database INTEGER type
-	movieIDInt, err := strconv.Atoi(movieID)
-	if err != nil {
-		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid movie ID"})
+	// Optionally ask YTS for Rotten Tomatoes ratings
+	withRTRatings := false
+	if param := r.URL.Query().Get("with_rt_ratings"); param != "" {
+		parsed, err := strconv.ParseBool(param)
+		if err != nil {
+			respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid with_rt_ratings value"})
+			return
+		}
+		withRTRatings = parsed
+	}
+
+	query := ytsListQuery{
+		Page:          pageNum,
+		Limit:         limit,
+		Query:         searchQuery,
+		SortBy:        sortBy,
+		OrderBy:       orderBy,
+		Genre:         genre,
+		WithRTRatings: withRTRatings,
+	}
+	if cached, ok := loadYTSCache(query); ok {
+		w.Header().Set("X-Cache", "HIT")
+		respondWithJSON(w, http.StatusOK, cached)
 		return
 	}
 
-	_, err = db.Exec("DELETE FROM favorites WHERE movie_id = ?", movieIDInt)
-	if err != nil {
-		log.Printf("Error removing favorite: %v", err)
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to remove favorite"})
+	apiResp, err := fetchYTSList(createSelectiveProxyClient(), query)
+	var apiErr *ytsAPIError
+	if errors.As(err, &apiErr) {
+		respondWithJSON(w, http.StatusBadGateway, map[string]string{"error": apiErr.message})
+		return
+	} else if err != nil {
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Removed from favorites"})
+	storeYTSCache(query, apiResp)
+	w.Header().Set("X-Cache", "MISS")
+	respondWithJSON(w, http.StatusOK, apiResp)
 }
 
-// Fetch YTS Movies Handler - Uses YTS API directly
-func fetchYTSMovies(w http.ResponseWriter, r *http.Request) {
-	// Add CORS headers
+// Handler for GET /api/v1/yts/genres
+// Lists the genres the browse endpoint's ?genre= accepts.
+func ytsGenresHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-	// Handle preflight requests
 	if r.Method == "OPTIONS" {
 		return
 	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"genres": ytsGenres,
+	})
+}
+
+// YTS browse pages, so paging back and forth doesn't hit the API again
+type ytsCacheEntry struct {
+	data      map[string]interface{}
+	fetchedAt time.Time
+}
+
+var (
+	ytsCacheMu sync.Mutex
+	ytsCache   = make(map[ytsListQuery]ytsCacheEntry)
+)
+
+// Return the cached response for q if it is younger than ytsCacheTTL
+func loadYTSCache(q ytsListQuery) (map[string]interface{}, bool) {
+	ytsCacheMu.Lock()
+	defer ytsCacheMu.Unlock()
+
+	entry, ok := ytsCache[q]
+	if !ok || time.Since(entry.fetchedAt) >= ytsCacheTTL {
+		return nil, false
+	}
+	return entry.data, true
+}
+
+// Cache a response, dropping expired entries so the map stays small
+func storeYTSCache(q ytsListQuery, data map[string]interface{}) {
+	ytsCacheMu.Lock()
+	defer ytsCacheMu.Unlock()
+
+	for k, entry := range ytsCache {
+		if time.Since(entry.fetchedAt) >= ytsCacheTTL {
+			delete(ytsCache, k)
+		}
+	}
+	ytsCache[q] = ytsCacheEntry{data: data, fetchedAt: time.Now()}
+}
+
+// Drop every cached YTS response and return how many there were
+func clearYTSCache() int {
+	ytsCacheMu.Lock()
+	defer ytsCacheMu.Unlock()
 
-	if r.Method != http.MethodGet {
+	cleared := len(ytsCache)
+	ytsCache = make(map[ytsListQuery]ytsCacheEntry)
+	return cleared
+}
+
+// Handler for POST /api/v1/yts/cache/clear
+// Flushes the YTS cache so new releases show up before the TTL runs out.
+// Only allowed from the local machine.
+func clearYTSCacheHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Get query parameters
-	requestedPage := r.URL.Query().Get("page")
-	if requestedPage == "" {
-		requestedPage = "1"
+	if !isLocalRequest(r) {
+		respondWithJSON(w, http.StatusForbidden, map[string]string{"error": "Clearing the YTS cache is only allowed from localhost"})
+		return
 	}
-	pageNum, _ := strconv.Atoi(requestedPage)
 
-	searchQuery := r.URL.Query().Get("query")
-	sortBy := r.URL.Query().Get("sort_by")
-	orderBy := r.URL.Query().Get("order_by")
+	cleared := clearYTSCache()
+	requestLogger(r.Context()).Printf("Cleared %d cached YTS responses", cleared)
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "YTS cache cleared",
+		"cleared": cleared,
+	})
+}
 
-	// Set defaults
-	if sortBy == "" {
-		sortBy = "date_added"
-	}
-	if orderBy == "" {
-		orderBy = "desc"
-	}
+// Error YTS reported in a response body with status other than "ok"
+type ytsAPIError struct {
+	message string
+}
 
-	client := createSelectiveProxyClient()
+func (e *ytsAPIError) Error() string {
+	return "YTS error: " + e.message
+}
+
+// Parameters of a YTS list_movies request
+type ytsListQuery struct {
+	Page          int
+	Limit         int
+	Query         string
+	SortBy        string
+	OrderBy       string
+	Genre         string // One of ytsGenres, or empty for all
+	WithRTRatings bool   // Include Rotten Tomatoes ratings
+}
 
+// Query the configured YTS list_movies endpoint and add a magnetUrl to
+// every torrent in the response. Everything else YTS returns, like
+// imdb_code and rt ratings, is passed through untouched.
+func fetchYTSList(client *http.Client, q ytsListQuery) (map[string]interface{}, error) {
 	// Get YTS server URL from settings
 	settingsMutex.RLock()
 	ytsServerURL := currentSettings.YTSServerURL
@@ -1678,41 +6964,55 @@ func fetchYTSMovies(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Build API URL with query parameters
-	apiURL := fmt.Sprintf("%s?page=%d&limit=20&sort_by=%s&order_by=%s", ytsServerURL, pageNum, sortBy, orderBy)
+	apiURL := fmt.Sprintf("%s?page=%d&limit=%d&sort_by=%s&order_by=%s", ytsServerURL, q.Page, q.Limit, q.SortBy, q.OrderBy)
 
 	// Add search query if provided
-	if searchQuery != "" {
-		apiURL += fmt.Sprintf("&query_term=%s", url.QueryEscape(searchQuery))
+	if q.Query != "" {
+		apiURL += fmt.Sprintf("&query_term=%s", url.QueryEscape(q.Query))
+	}
+	if q.Genre != "" {
+		apiURL += "&genre=" + url.QueryEscape(q.Genre)
+	}
+	if q.WithRTRatings {
+		apiURL += "&with_rt_ratings=true"
 	}
 
 	req, err := http.NewRequest("GET", apiURL, nil)
 	if err != nil {
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to create request"})
-		return
+		return nil, errors.New("Failed to create request")
 	}
 	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
 
 	resp, err := client.Do(req)
 	if err != nil {
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to fetch movies"})
-		return
+		return nil, errors.New("Failed to fetch movies")
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to read response"})
-		return
+		return nil, errors.New("Failed to read response")
 	}
 
 	var apiResp map[string]interface{}
 	if err := json.Unmarshal(body, &apiResp); err != nil {
-		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to parse response"})
-		return
+		return nil, errors.New("Failed to parse response")
+	}
+
+	// YTS reports problems like an invalid query in the body, not the status
+	if status, _ := apiResp["status"].(string); status != "ok" {
+		message, _ := apiResp["status_message"].(string)
+		if message == "" {
+			message = "YTS returned status " + strconv.Quote(status)
+		}
+		return nil, &ytsAPIError{message: message}
 	}
 
 	// Add magnet URLs to torrents
 	if data, ok := apiResp["data"].(map[string]interface{}); ok {
+		// Report the page size we actually asked for
+		data["limit"] = q.Limit
+
 		if movies, ok := data["movies"].([]interface{}); ok {
 			for _, movieInterface := range movies {
 				if movie, ok := movieInterface.(map[string]interface{}); ok {
@@ -1725,10 +7025,7 @@ func fetchYTSMovies(w http.ResponseWriter, r *http.Request) {
 										if q, ok := torrent["quality"].(string); ok {
 											quality = q
 										}
-										magnetLink := fmt.Sprintf("magnet:?xt=urn:btih:%s&dn=%s+%s&tr=udp://open.demonii.com:1337/announce&tr=udp://tracker.openbittorrent.com:80&tr=udp://tracker.coppersurfer.tk:6969&tr=udp://glotorrents.pw:6969/announce&tr=udp://tracker.opentrackr.org:1337/announce&tr=udp://torrent.gresille.org:80/announce&tr=udp://p4p.arenabg.com:1337&tr=udp://tracker.leechers-paradise.org:6969",
-											hash,
-											strings.ReplaceAll(title, " ", "+"),
-											quality)
+										magnetLink := buildYTSMagnet(hash, title+" "+quality)
 										torrent["magnetUrl"] = magnetLink
 									}
 								}
@@ -1740,7 +7037,153 @@ func fetchYTSMovies(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	respondWithJSON(w, http.StatusOK, apiResp)
+	return apiResp, nil
+}
+
+// Trackers added to YTS magnets when no remote tracker list is available
+var builtinTrackers = []string{
+	"udp://open.demonii.com:1337/announce",
+	"udp://tracker.openbittorrent.com:80",
+	"udp://tracker.coppersurfer.tk:6969",
+	"udp://glotorrents.pw:6969/announce",
+	"udp://tracker.opentrackr.org:1337/announce",
+	"udp://torrent.gresille.org:80/announce",
+	"udp://p4p.arenabg.com:1337",
+	"udp://tracker.leechers-paradise.org:6969",
+}
+
+// Last tracker list fetched from TrackerListURL
+var (
+	remoteTrackersMu        sync.RWMutex
+	remoteTrackers          []string
+	remoteTrackersFetchedAt time.Time
+)
+
+// Trackers for generated magnets: the remote list while it is fresh,
+// otherwise the built-in one
+func defaultTrackers() []string {
+	remoteTrackersMu.RLock()
+	defer remoteTrackersMu.RUnlock()
+	if len(remoteTrackers) > 0 && time.Since(remoteTrackersFetchedAt) < trackerListTTL {
+		return remoteTrackers
+	}
+	return builtinTrackers
+}
+
+// Build a magnet for a YTS torrent hash with the default trackers
+func buildYTSMagnet(hash, name string) string {
+	magnetLink := fmt.Sprintf("magnet:?xt=urn:btih:%s&dn=%s", hash, escapeMagnetName(name))
+	for _, tracker := range defaultTrackers() {
+		magnetLink += "&tr=" + url.QueryEscape(tracker)
+	}
+	return magnetLink
+}
+
+// Fetch the tracker list from TrackerListURL through the proxy. Returns the
+// number of trackers loaded; on failure the previous list is kept.
+func refreshTrackers() (int, error) {
+	settingsMutex.RLock()
+	listURL := currentSettings.TrackerListURL
+	settingsMutex.RUnlock()
+
+	if listURL == "" {
+		return 0, errors.New("no tracker list URL configured")
+	}
+
+	resp, err := createSelectiveProxyClient().Get(listURL)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch tracker list: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("tracker list returned status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxTrackerListSize))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read tracker list: %w", err)
+	}
+
+	trackers := parseTrackerList(string(body))
+	if len(trackers) == 0 {
+		return 0, errors.New("tracker list is empty")
+	}
+
+	remoteTrackersMu.Lock()
+	remoteTrackers = trackers
+	remoteTrackersFetchedAt = time.Now()
+	remoteTrackersMu.Unlock()
+
+	return len(trackers), nil
+}
+
+// Parse a newline-separated tracker list, skipping blank lines, comments,
+// anything that isn't a tracker URL and duplicates
+func parseTrackerList(list string) []string {
+	var trackers []string
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(list, "\n") {
+		tracker := strings.TrimSpace(line)
+		if tracker == "" || strings.HasPrefix(tracker, "#") || seen[tracker] {
+			continue
+		}
+		parsed, err := url.Parse(tracker)
+		if err != nil {
+			continue
+		}
+		switch parsed.Scheme {
+		case "udp", "http", "https", "ws", "wss":
+			seen[tracker] = true
+			trackers = append(trackers, tracker)
+		}
+	}
+	return trackers
+}
+
+// Keep the remote tracker list up to date
+func refreshTrackersPeriodically() {
+	ticker := time.NewTicker(trackerListRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		settingsMutex.RLock()
+		listURL := currentSettings.TrackerListURL
+		settingsMutex.RUnlock()
+
+		if listURL != "" {
+			if count, err := refreshTrackers(); err != nil {
+				log.Printf("Tracker list refresh failed: %v", err)
+			} else {
+				log.Printf("Loaded %d trackers from %s", count, listURL)
+			}
+		}
+		<-ticker.C
+	}
+}
+
+// Handler for POST /api/v1/trackers/refresh
+func refreshTrackersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	if r.Method == "OPTIONS" {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	count, err := refreshTrackers()
+	if err != nil {
+		respondWithJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message":  "Tracker list refreshed",
+		"trackers": count,
+	})
 }
 
 func fetchMovieTorrents(client *http.Client, title string, movieData map[string]interface{}) []interface{} {
@@ -1791,10 +7234,7 @@ func fetchMovieTorrents(client *http.Client, title string, movieData map[string]
 								if q, ok := torrentMap["quality"].(string); ok {
 									quality = q
 								}
-								magnetLink := fmt.Sprintf("magnet:?xt=urn:btih:%s&dn=%s+%s&tr=udp://open.demonii.com:1337/announce&tr=udp://tracker.openbittorrent.com:80&tr=udp://tracker.coppersurfer.tk:6969&tr=udp://glotorrents.pw:6969/announce&tr=udp://tracker.opentrackr.org:1337/announce&tr=udp://torrent.gresille.org:80/announce&tr=udp://p4p.arenabg.com:1337&tr=udp://tracker.leechers-paradise.org:6969",
-									hash,
-									strings.ReplaceAll(title, " ", "+"),
-									quality)
+								magnetLink := buildYTSMagnet(hash, title+" "+quality)
 								torrentMap["magnetUrl"] = magnetLink
 							}
 						}
@@ -1849,9 +7289,7 @@ func parseYTSMovies(html string) ([]map[string]interface{}, int) {
 
 		// Extract title
 		if idx := strings.Index(part, `class="browse-movie-title"`); idx != -1 {
-			titleStart := strings.Index(part[idx:], `>`) + idx + 1
-			if titleEnd := strings.Index(part[titleStart:], `</a>`); titleEnd != -1 {
-				title := part[titleStart : titleStart+titleEnd]
+			if title, ok := innerTextAt(part, idx, `</a>`); ok {
 				// Remove [ZH] tag if present
 				title = strings.TrimSpace(strings.ReplaceAll(title, `<span style="color: #ACD7DE; font-size: 75%;">[ZH]</span>`, ""))
 				movie["title"] = title
@@ -1861,10 +7299,8 @@ func parseYTSMovies(html string) ([]map[string]interface{}, int) {
 
 		// Extract year
 		if idx := strings.Index(part, `class="browse-movie-year"`); idx != -1 {
-			yearStart := strings.Index(part[idx:], `>`) + idx + 1
-			if yearEnd := strings.Index(part[yearStart:], `</div>`); yearEnd != -1 {
-				year := strings.TrimSpace(part[yearStart : yearStart+yearEnd])
-				movie["year"], _ = strconv.Atoi(year)
+			if year, ok := innerTextAt(part, idx, `</div>`); ok {
+				movie["year"], _ = strconv.Atoi(strings.TrimSpace(year))
 			}
 		}
 
@@ -1894,7 +7330,8 @@ func parseYTSMovies(html string) ([]map[string]interface{}, int) {
 		// For now, provide empty array - will be populated when user clicks
 		movie["torrents"] = []interface{}{}
 
-		if len(movie) > 0 {
+		// Skip truncated cards that didn't yield a title
+		if _, ok := movie["title"]; ok {
 			movies = append(movies, movie)
 		}
 	}
@@ -1902,6 +7339,24 @@ func parseYTSMovies(html string) ([]map[string]interface{}, int) {
 	return movies, totalPages
 }
 
+// Return the text between the end of the tag that starts at idx and endMarker.
+// ok is false when the markup is truncated, so callers never slice out of range.
+func innerTextAt(s string, idx int, endMarker string) (string, bool) {
+	if idx < 0 || idx >= len(s) {
+		return "", false
+	}
+	tagEnd := strings.Index(s[idx:], ">")
+	if tagEnd == -1 {
+		return "", false
+	}
+	start := idx + tagEnd + 1
+	end := strings.Index(s[start:], endMarker)
+	if end == -1 {
+		return "", false
+	}
+	return s[start : start+end], true
+}
+
 func extractCSRFToken(html string) string {
 	// Extract _token from meta tag or input field
 	if idx := strings.Index(html, `name="_token" content="`); idx != -1 {
@@ -2015,14 +7470,20 @@ func fetchAvmooMovies(w http.ResponseWriter, r *http.Request) {
 		page = "1"
 	}
 
-	client := createSelectiveProxyClient()
+	// Optional keyword search or genre filter instead of the browse listing
+	fetchURL := avmooListURL(page, r.URL.Query().Get("q"), r.URL.Query().Get("genre"))
 
-	// Construct URL with page parameter
-	fetchURL := fmt.Sprintf("https://avmoo.website/cn/page/%s", page)
-	if page == "1" {
-		fetchURL = "https://avmoo.website/cn"
+	// Serve a recent scrape unless the client asks for fresh data
+	cacheKey := "list:" + fetchURL
+	noCache := r.URL.Query().Get("nocache") == "1"
+	if cached, ok := loadAvmooCache(cacheKey); ok && !noCache {
+		w.Header().Set("X-Cache", "HIT")
+		respondWithJSON(w, http.StatusOK, cached)
+		return
 	}
 
+	client := createSelectiveProxyClient()
+
 	req, err := http.NewRequest("GET", fetchURL, nil)
 	if err != nil {
 		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to create request"})
@@ -2052,18 +7513,102 @@ func fetchAvmooMovies(w http.ResponseWriter, r *http.Request) {
 
 	// Parse HTML to extract movie data
 	movies := parseAvmooMovies(string(htmlBody))
+	currentPage, _ := strconv.Atoi(page)
 
 	response := map[string]interface{}{
 		"status": "ok",
 		"data": map[string]interface{}{
-			"page":   page,
-			"movies": movies,
+			"page":       page,
+			"totalPages": parseAvmooTotalPages(string(htmlBody), currentPage),
+			"movies":     movies,
 		},
 	}
 
+	storeAvmooCache(cacheKey, response)
+	w.Header().Set("X-Cache", "MISS")
 	respondWithJSON(w, http.StatusOK, response)
 }
 
+// Parsed Avmoo responses, so repeat views don't scrape the site again
+type avmooCacheEntry struct {
+	data      map[string]interface{}
+	fetchedAt time.Time
+}
+
+var (
+	avmooCacheMu sync.Mutex
+	avmooCache   = make(map[string]avmooCacheEntry)
+)
+
+// Return the cached response for key if it is younger than avmooCacheTTL
+func loadAvmooCache(key string) (map[string]interface{}, bool) {
+	avmooCacheMu.Lock()
+	defer avmooCacheMu.Unlock()
+
+	entry, ok := avmooCache[key]
+	if !ok || time.Since(entry.fetchedAt) >= avmooCacheTTL {
+		return nil, false
+	}
+	return entry.data, true
+}
+
+// Cache a parsed response, dropping expired entries so the map stays small
+func storeAvmooCache(key string, data map[string]interface{}) {
+	avmooCacheMu.Lock()
+	defer avmooCacheMu.Unlock()
+
+	for k, entry := range avmooCache {
+		if time.Since(entry.fetchedAt) >= avmooCacheTTL {
+			delete(avmooCache, k)
+		}
+	}
+	avmooCache[key] = avmooCacheEntry{data: data, fetchedAt: time.Now()}
+}
+
+// Avmoo listing URL for a page of the browse listing, a keyword search
+// or a genre. A keyword takes precedence over a genre.
+func avmooListURL(page, query, genre string) string {
+	base := "https://avmoo.website/cn"
+	if query != "" {
+		base += "/search/" + url.PathEscape(query)
+	} else if genre != "" {
+		base += "/genre/" + url.PathEscape(genre)
+	}
+
+	if page == "1" {
+		return base
+	}
+	return fmt.Sprintf("%s/page/%s", base, url.PathEscape(page))
+}
+
+// Highest page number linked from the pagination block. Falls back to the
+// current page when there is no pagination (a single page of results) or
+// it can't be parsed.
+func parseAvmooTotalPages(html string, currentPage int) int {
+	totalPages := max(currentPage, 1)
+
+	idx := strings.Index(html, `class="pagination`)
+	if idx == -1 {
+		return totalPages
+	}
+	paginationSection := html[idx:]
+	if end := strings.Index(paginationSection, `</ul>`); end != -1 {
+		paginationSection = paginationSection[:end]
+	}
+
+	pageMarkers := strings.Split(paginationSection, `/page/`)
+	for _, marker := range pageMarkers[1:] {
+		end := strings.IndexAny(marker, `"'/?`)
+		if end == -1 {
+			continue
+		}
+		if pageNum, err := strconv.Atoi(marker[:end]); err == nil && pageNum > totalPages {
+			totalPages = pageNum
+		}
+	}
+	return totalPages
+}
+
 func parseAvmooMovies(html string) []map[string]interface{} {
 	var movies []map[string]interface{}
 
@@ -2102,10 +7647,8 @@ func parseAvmooMovies(html string) []map[string]interface{} {
 
 		// Extract title
 		if idx := strings.Index(part, `<span class="video-title"`); idx != -1 {
-			titleStart := strings.Index(part[idx:], `>`) + idx + 1
-			if titleEnd := strings.Index(part[titleStart:], `</span>`); titleEnd != -1 {
-				title := strings.TrimSpace(part[titleStart : titleStart+titleEnd])
-				movie["title"] = title
+			if title, ok := innerTextAt(part, idx, `</span>`); ok {
+				movie["title"] = strings.TrimSpace(title)
 			}
 		}
 
@@ -2154,6 +7697,15 @@ func fetchAvmooMovieDetail(w http.ResponseWriter, r *http.Request) {
 	}
 	movieID := parts[5]
 
+	// Serve a recent scrape unless the client asks for fresh data
+	cacheKey := "detail:" + movieID
+	noCache := r.URL.Query().Get("nocache") == "1"
+	if cached, ok := loadAvmooCache(cacheKey); ok && !noCache {
+		w.Header().Set("X-Cache", "HIT")
+		respondWithJSON(w, http.StatusOK, cached)
+		return
+	}
+
 	client := createSelectiveProxyClient()
 
 	// Construct movie detail URL
@@ -2194,6 +7746,8 @@ func fetchAvmooMovieDetail(w http.ResponseWriter, r *http.Request) {
 		"data":   movieDetail,
 	}
 
+	storeAvmooCache(cacheKey, response)
+	w.Header().Set("X-Cache", "MISS")
 	respondWithJSON(w, http.StatusOK, response)
 }
 
@@ -2251,10 +7805,12 @@ func parseAvmooMovieDetail(html string) map[string]interface{} {
 
 	// Extract additional info if available
 	if idx := strings.Index(html, `<span class="header">發行日期:`); idx != -1 {
-		dateStart := strings.Index(html[idx:], `</span>`) + idx + len(`</span>`)
-		if dateEnd := strings.Index(html[dateStart:], `</p>`); dateEnd != -1 {
-			date := strings.TrimSpace(html[dateStart : dateStart+dateEnd])
-			movie["releaseDate"] = date
+		if spanEnd := strings.Index(html[idx:], `</span>`); spanEnd != -1 {
+			dateStart := idx + spanEnd + len(`</span>`)
+			if dateEnd := strings.Index(html[dateStart:], `</p>`); dateEnd != -1 {
+				date := strings.TrimSpace(html[dateStart : dateStart+dateEnd])
+				movie["releaseDate"] = date
+			}
 		}
 	}
 
@@ -2264,12 +7820,19 @@ func parseAvmooMovieDetail(html string) map[string]interface{} {
 func fetchMagnetsFromBtsow(query string) []string {
 	var magnets []string
 
+	settingsMutex.RLock()
+	maxResults := currentSettings.BtsowMaxResults
+	settingsMutex.RUnlock()
+
 	client := createSelectiveProxyClient()
 
 	// Try to fetch HTML search page
 	searchURL := fmt.Sprintf("https://btsow.lol/search/%s", url.QueryEscape(query))
 
-	req, err := http.NewRequest("GET", searchURL, nil)
+	// The shared client allows 30s; a scrape fallback shouldn't hold a search that long
+	ctx, cancel := context.WithTimeout(context.Background(), btsowTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", searchURL, nil)
 	if err != nil {
 		log.Printf("Error creating btsow request: %v", err)
 		return magnets
@@ -2313,23 +7876,17 @@ func fetchMagnetsFromBtsow(query string) []string {
 		}
 
 		magnetHash := parts[i][:end]
+		if !validInfohash(magnetHash) {
+			continue
+		}
 		magnetURL := magnetPrefix + magnetHash
 
 		// Only add unique magnets
-		isDuplicate := false
-		for _, existing := range magnets {
-			if existing == magnetURL {
-				isDuplicate = true
-				break
-			}
-		}
-
-		if !isDuplicate && len(magnetURL) > 50 {
+		if !slices.Contains(magnets, magnetURL) {
 			magnets = append(magnets, magnetURL)
 		}
 
-		// Limit to 10 results
-		if len(magnets) >= 10 {
+		if len(magnets) >= maxResults {
 			break
 		}
 	}
@@ -2338,6 +7895,19 @@ func fetchMagnetsFromBtsow(query string) []string {
 	return magnets
 }
 
+// Whether s is a v1 infohash as magnets carry it: 40 hex or 32 base32 characters
+func validInfohash(s string) bool {
+	switch len(s) {
+	case 40:
+		_, err := hex.DecodeString(s)
+		return err == nil
+	case 32:
+		_, err := base32.StdEncoding.DecodeString(strings.ToUpper(s))
+		return err == nil
+	}
+	return false
+}
+
 // Convert Torrent to Magnet Handler
 func convertTorrentToMagnetHandler(w http.ResponseWriter, r *http.Request) {
 	// Set CORS headers
@@ -2353,8 +7923,19 @@ func convertTorrentToMagnetHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse multipart form with 10MB memory limit
-	const maxUploadSize = 10 << 20 // 10MB
+	maxTrackers := defaultMaxMagnetTrackers
+	if param := r.URL.Query().Get("maxTrackers"); param != "" {
+		n, err := strconv.Atoi(param)
+		if err != nil || n < 0 {
+			respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid maxTrackers value"})
+			return
+		}
+		maxTrackers = n
+	}
+
+	// Parse multipart form, keeping up to the upload limit in memory
+	maxUploadSize := getMaxUploadSize()
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize+1<<20) // Room for the multipart framing
 	if err := r.ParseMultipartForm(maxUploadSize); err != nil {
 		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Failed to parse form: " + err.Error()})
 		return
@@ -2381,13 +7962,124 @@ func convertTorrentToMagnetHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse torrent file
-	mi, err := metainfo.Load(bytes.NewReader(fileBytes))
+	// Parse torrent file, tolerating benign junk around the info dict
+	mi, err := loadMetaInfoLenient(fileBytes)
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid torrent file: " + err.Error()})
+		return
+	}
+
+	magnet := magnetFromMetaInfo(mi, maxTrackers)
+
+	respondWithJSON(w, http.StatusOK, map[string]string{
+		"magnet": magnet,
+	})
+}
+
+// Largest .torrent upload accepted by the converter and inspector
+func getMaxUploadSize() int64 {
+	settingsMutex.RLock()
+	defer settingsMutex.RUnlock()
+	return currentSettings.MaxUploadSize
+}
+
+// Handler for POST /api/v1/torrent/inspect-file
+// Returns a JSON view of an uploaded .torrent's metainfo for debugging
+func inspectTorrentFileHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	maxUploadSize := getMaxUploadSize()
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize+1<<20) // Room for the multipart framing
+	if err := r.ParseMultipartForm(maxUploadSize); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Failed to parse form: " + err.Error()})
+		return
+	}
+
+	file, header, err := r.FormFile("torrent")
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Missing torrent file"})
+		return
+	}
+	defer file.Close()
+
+	if header.Size > maxUploadSize {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "File too large"})
+		return
+	}
+
+	fileBytes, err := io.ReadAll(file)
+	if err != nil {
+		respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to read file"})
+		return
+	}
+
+	mi, err := loadMetaInfoLenient(fileBytes)
 	if err != nil {
 		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid torrent file: " + err.Error()})
 		return
 	}
 
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid info dictionary: " + err.Error()})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, inspectMetaInfo(mi, &info))
+}
+
+// JSON-friendly view of a torrent's metainfo and info dictionary
+func inspectMetaInfo(mi *metainfo.MetaInfo, info *metainfo.Info) map[string]interface{} {
+	files := []map[string]interface{}{}
+	for _, fileInfo := range info.UpvertedFiles() {
+		files = append(files, map[string]interface{}{
+			"path":   fileInfo.DisplayPath(info),
+			"length": fileInfo.Length,
+		})
+	}
+
+	// Single-tracker torrents only have the announce key
+	announceTiers := [][]string{}
+	for _, tier := range mi.AnnounceList {
+		announceTiers = append(announceTiers, tier)
+	}
+	if len(announceTiers) == 0 && mi.Announce != "" {
+		announceTiers = append(announceTiers, []string{mi.Announce})
+	}
+
+	var creationDate interface{}
+	if mi.CreationDate > 0 {
+		creationDate = time.Unix(mi.CreationDate, 0).UTC()
+	}
+
+	return map[string]interface{}{
+		"infoHash":      mi.HashInfoBytes().HexString(),
+		"name":          info.BestName(),
+		"pieceLength":   info.PieceLength,
+		"pieceCount":    info.NumPieces(),
+		"totalLength":   info.TotalLength(),
+		"files":         files,
+		"private":       info.Private != nil && *info.Private,
+		"creationDate":  creationDate,
+		"createdBy":     mi.CreatedBy,
+		"comment":       mi.Comment,
+		"announceTiers": announceTiers,
+	}
+}
+
+// Build a magnet link from parsed metainfo, appending at most maxTrackers
+// distinct trackers in tier order
+func magnetFromMetaInfo(mi *metainfo.MetaInfo, maxTrackers int) string {
 	// Get info hash
 	infoHash := mi.HashInfoBytes().String()
 
@@ -2397,17 +8089,65 @@ func convertTorrentToMagnetHandler(w http.ResponseWriter, r *http.Request) {
 	// Add display name
 	info, err := mi.UnmarshalInfo()
 	if err == nil {
-		magnet += fmt.Sprintf("&dn=%s", url.QueryEscape(info.Name))
+		magnet += fmt.Sprintf("&dn=%s", escapeMagnetName(info.Name))
 	}
 
-	// Add trackers
+	// Add trackers, flattening the tiers and skipping duplicates
+	seen := make(map[string]bool)
 	for _, tier := range mi.AnnounceList {
 		for _, tracker := range tier {
+			if len(seen) >= maxTrackers {
+				return magnet
+			}
+			if seen[tracker] {
+				continue
+			}
+			seen[tracker] = true
 			magnet += fmt.Sprintf("&tr=%s", url.QueryEscape(tracker))
 		}
 	}
 
-	respondWithJSON(w, http.StatusOK, map[string]string{
-		"magnet": magnet,
-	})
+	return magnet
+}
+
+// Escape a display name for a magnet dn parameter. QueryEscape turns
+// spaces into "+", which not every client reads back as a space.
+func escapeMagnetName(name string) string {
+	return strings.ReplaceAll(url.QueryEscape(name), "+", "%20")
+}
+
+// Parse a .torrent file, falling back to a lenient path for files with
+// trailing bytes or non-standard fields as long as the info dict is intact
+func loadMetaInfoLenient(data []byte) (*metainfo.MetaInfo, error) {
+	mi, err := metainfo.Load(bytes.NewReader(data))
+	if err == nil {
+		return mi, nil
+	}
+
+	// Load still returns the decoded metainfo when only the trailing bytes are bad
+	if mi != nil && len(mi.InfoBytes) > 0 {
+		log.Printf("Ignoring torrent parse error after metainfo: %v", err)
+		return mi, nil
+	}
+
+	// Otherwise locate the raw info dictionary and take it verbatim,
+	// so the infohash still matches what peers expect
+	idx := bytes.Index(data, []byte("4:infod"))
+	if idx == -1 {
+		return nil, err
+	}
+
+	var infoBytes bencode.Bytes
+	decoder := bencode.NewDecoder(bytes.NewReader(data[idx+len("4:info"):]))
+	if decodeErr := decoder.Decode(&infoBytes); decodeErr != nil {
+		return nil, err
+	}
+
+	recovered := &metainfo.MetaInfo{InfoBytes: infoBytes}
+	if _, infoErr := recovered.UnmarshalInfo(); infoErr != nil {
+		return nil, err
+	}
+
+	log.Printf("Recovered info dict from malformed torrent: %v", err)
+	return recovered, nil
 }
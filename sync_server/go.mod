@@ -1,3 +1,5 @@
 module sync_server
 
 go 1.24.4
+
+require golang.org/x/sync v0.8.0
@@ -1,15 +1,23 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"math/rand"
 	"net/http"
 	"net/url"
+	"os"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"golang.org/x/sync/singleflight"
 )
 
 const (
@@ -17,17 +25,123 @@ const (
 	SYNC_INTERVAL  = 5 * time.Minute
 	MAX_PAGES      = 10 // Cache first 10 pages of movies
 	DEFAULT_PORT   = 8080
+
+	YTS_DETAILS_URL = "https://yts.mx/api/v2/movie_details.json"
+
+	SYNC_RETRIES      = 3                      // Attempts per page during a sync
+	RETRY_BASE_DELAY  = 1 * time.Second        // Doubled after every failed attempt
+	SYNC_DELAY_MIN    = 400 * time.Millisecond // Randomized pause between sync requests
+	SYNC_DELAY_JITTER = 800 * time.Millisecond
+
+	CACHE_TTL = 2 * SYNC_INTERVAL // Entries older than this are refetched, but kept as a fallback
+
+	YTS_TIMEOUT = 15 * time.Second // Upper bound for a single YTS request
+
+	CLIENT_MAX_AGE = SYNC_INTERVAL // How long browsers and CDNs may reuse a response
 )
 
 // Cache structure to store YTS API responses
 type MovieCache struct {
 	sync.RWMutex
-	data         map[string]interface{} // Stores full API responses by cache key
+	data         map[string]*CacheEntry // Stores full API responses by cache key
 	lastSync     time.Time
 }
 
+// A cached API response and when it was fetched
+type CacheEntry struct {
+	data      map[string]interface{}
+	fetchedAt time.Time
+}
+
 var cache = &MovieCache{
-	data: make(map[string]interface{}),
+	data: make(map[string]*CacheEntry),
+}
+
+// Counters exposed at /stats for tuning the cache
+type Stats struct {
+	cacheHits   atomic.Int64
+	cacheMisses atomic.Int64
+	staleServed atomic.Int64
+	syncBySort  sync.Map // sort name -> *SyncCounters
+}
+
+// Sync outcomes for one sort combination
+type SyncCounters struct {
+	Successes atomic.Int64
+	Failures  atomic.Int64
+}
+
+var stats = &Stats{}
+
+// Record the outcome of syncing one page for a sort combination
+func (s *Stats) recordSync(sortName string, err error) {
+	value, _ := s.syncBySort.LoadOrStore(sortName, &SyncCounters{})
+	counters := value.(*SyncCounters)
+	if err != nil {
+		counters.Failures.Add(1)
+	} else {
+		counters.Successes.Add(1)
+	}
+}
+
+// Origins allowed by CORS, from the comma-separated CORS_ORIGINS env var.
+// Empty means any origin ("*").
+var allowedOrigins []string
+
+func loadAllowedOrigins() {
+	for _, origin := range strings.Split(os.Getenv("CORS_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			allowedOrigins = append(allowedOrigins, origin)
+		}
+	}
+}
+
+// Set Access-Control-Allow-Origin, echoing the origin only when it is allowlisted
+func setCORSHeaders(w http.ResponseWriter, r *http.Request) {
+	if len(allowedOrigins) == 0 {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+
+	w.Header().Add("Vary", "Origin")
+	origin := r.Header.Get("Origin")
+	for _, allowed := range allowedOrigins {
+		if origin == allowed {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			return
+		}
+	}
+}
+
+// Token that POST /warmup requires as "Authorization: Bearer
+// <token>", from the SYNC_TOKEN env var. Empty disables warm-up.
+var syncToken string
+
+func loadSyncToken() {
+	syncToken = strings.TrimSpace(os.Getenv("SYNC_TOKEN"))
+}
+
+// Whether the request carries the sync token
+func hasSyncToken(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && syncToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(syncToken)) == 1
+}
+
+// Coalesces concurrent cache misses for the same key into one upstream fetch
+var fetchGroup singleflight.Group
+
+// Store an API response under its cache key
+func storeInCache(cacheKey string, data map[string]interface{}) *CacheEntry {
+	entry := &CacheEntry{data: data, fetchedAt: time.Now()}
+	cache.Lock()
+	cache.data[cacheKey] = entry
+	cache.Unlock()
+	return entry
+}
+
+// Outbound client for YTS so a hung connection can't stall a sync forever
+var ytsClient = &http.Client{
+	Timeout: YTS_TIMEOUT,
 }
 
 func init() {
@@ -44,7 +158,7 @@ func getCacheKey(page, limit int, query, sortBy, orderBy string) string {
 }
 
 // Fetch data from YTS.mx API
-func fetchFromYTS(page, limit int, query, sortBy, orderBy string) (map[string]interface{}, error) {
+func fetchFromYTS(ctx context.Context, page, limit int, query, sortBy, orderBy string, withRTRatings bool) (map[string]interface{}, error) {
 	// Set defaults
 	if sortBy == "" {
 		sortBy = "date_added"
@@ -58,8 +172,58 @@ func fetchFromYTS(page, limit int, query, sortBy, orderBy string) (map[string]in
 	if query != "" {
 		apiURL = fmt.Sprintf("%s&query_term=%s", apiURL, url.QueryEscape(query))
 	}
+	if withRTRatings {
+		apiURL += "&with_rt_ratings=true"
+	}
+
+	result, err := fetchYTSJSON(ctx, apiURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Add magnet URLs to torrents (same as main server)
+	if data, ok := result["data"].(map[string]interface{}); ok {
+		if movies, ok := data["movies"].([]interface{}); ok {
+			for _, movieInterface := range movies {
+				if movie, ok := movieInterface.(map[string]interface{}); ok {
+					addMagnetLinks(movie)
+				}
+			}
+		}
+	}
 
-	resp, err := http.Get(apiURL)
+	return result, nil
+}
+
+// Fetch a single movie's details from the YTS.mx API
+func fetchMovieDetails(ctx context.Context, movieID int, withImages, withCast, withRTRatings bool) (map[string]interface{}, error) {
+	apiURL := fmt.Sprintf("%s?movie_id=%d&with_images=%t&with_cast=%t", YTS_DETAILS_URL, movieID, withImages, withCast)
+	if withRTRatings {
+		apiURL += "&with_rt_ratings=true"
+	}
+
+	result, err := fetchYTSJSON(ctx, apiURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if data, ok := result["data"].(map[string]interface{}); ok {
+		if movie, ok := data["movie"].(map[string]interface{}); ok {
+			addMagnetLinks(movie)
+		}
+	}
+
+	return result, nil
+}
+
+// GET a YTS API URL and decode the JSON body
+func fetchYTSJSON(ctx context.Context, apiURL string) (map[string]interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := ytsClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch from YTS: %w", err)
 	}
@@ -74,55 +238,103 @@ func fetchFromYTS(page, limit int, query, sortBy, orderBy string) (map[string]in
 		return nil, fmt.Errorf("failed to decode YTS response: %w", err)
 	}
 
-	// Add magnet URLs to torrents (same as main server)
-	if data, ok := result["data"].(map[string]interface{}); ok {
-		if movies, ok := data["movies"].([]interface{}); ok {
-			for _, movieInterface := range movies {
-				if movie, ok := movieInterface.(map[string]interface{}); ok {
-					if title, ok := movie["title"].(string); ok {
-						if torrents, ok := movie["torrents"].([]interface{}); ok {
-							for _, torrentInterface := range torrents {
-								if torrent, ok := torrentInterface.(map[string]interface{}); ok {
-									if hash, ok := torrent["hash"].(string); ok {
-										quality := ""
-										if q, ok := torrent["quality"].(string); ok {
-											quality = q
-										}
-
-										// Generate magnet link with trackers
-										trackers := []string{
-											"udp://open.demonii.com:1337/announce",
-											"udp://tracker.openbittorrent.com:80",
-											"udp://tracker.coppersurfer.tk:6969",
-											"udp://glotorrents.pw:6969/announce",
-											"udp://tracker.opentrackr.org:1337/announce",
-											"udp://torrent.gresille.org:80/announce",
-											"udp://p4p.arenabg.com:1337",
-											"udp://tracker.leechers-paradise.org:6969",
-										}
-
-										magnetLink := fmt.Sprintf("magnet:?xt=urn:btih:%s&dn=%s+%s",
-											hash,
-											url.QueryEscape(title),
-											quality,
-										)
-
-										for _, tracker := range trackers {
-											magnetLink += "&tr=" + url.QueryEscape(tracker)
-										}
-
-										torrent["magnetUrl"] = magnetLink
-									}
-								}
-							}
-						}
-					}
+	return result, nil
+}
+
+// Add a magnetUrl to each of a movie's torrents
+func addMagnetLinks(movie map[string]interface{}) {
+	title, ok := movie["title"].(string)
+	if !ok {
+		return
+	}
+	torrents, ok := movie["torrents"].([]interface{})
+	if !ok {
+		return
+	}
+
+	for _, torrentInterface := range torrents {
+		if torrent, ok := torrentInterface.(map[string]interface{}); ok {
+			if hash, ok := torrent["hash"].(string); ok {
+				quality := ""
+				if q, ok := torrent["quality"].(string); ok {
+					quality = q
 				}
+				torrent["magnetUrl"] = buildMagnetLink(hash, title, quality)
 			}
 		}
 	}
+}
 
-	return result, nil
+// Default trackers, kept in sync with the main bitplay server
+var defaultTrackers = []string{
+	"udp://open.demonii.com:1337/announce",
+	"udp://tracker.openbittorrent.com:80",
+	"udp://tracker.coppersurfer.tk:6969",
+	"udp://glotorrents.pw:6969/announce",
+	"udp://tracker.opentrackr.org:1337/announce",
+	"udp://torrent.gresille.org:80/announce",
+	"udp://p4p.arenabg.com:1337",
+	"udp://tracker.leechers-paradise.org:6969",
+}
+
+// Trackers added to generated magnets. Override with the comma-separated
+// MAGNET_TRACKERS env var.
+var magnetTrackers = defaultTrackers
+
+func loadMagnetTrackers() {
+	var trackers []string
+	for _, tracker := range strings.Split(os.Getenv("MAGNET_TRACKERS"), ",") {
+		if tracker = strings.TrimSpace(tracker); tracker != "" {
+			trackers = append(trackers, tracker)
+		}
+	}
+	if len(trackers) > 0 {
+		magnetTrackers = trackers
+	}
+}
+
+// Generate magnet link with trackers
+func buildMagnetLink(hash, title, quality string) string {
+	magnetLink := fmt.Sprintf("magnet:?xt=urn:btih:%s&dn=%s",
+		hash,
+		escapeMagnetName(title+" "+quality),
+	)
+
+	for _, tracker := range magnetTrackers {
+		magnetLink += "&tr=" + url.QueryEscape(tracker)
+	}
+
+	return magnetLink
+}
+
+// Escape a display name for a magnet dn parameter, using %20 rather
+// than "+" for spaces so every client shows the name correctly
+func escapeMagnetName(name string) string {
+	return strings.ReplaceAll(url.QueryEscape(name), "+", "%20")
+}
+
+// Fetch from YTS, retrying with exponential backoff and jitter
+func fetchWithRetry(ctx context.Context, page, limit int, query, sortBy, orderBy string) (map[string]interface{}, error) {
+	var lastErr error
+	delay := RETRY_BASE_DELAY
+	for attempt := 1; attempt <= SYNC_RETRIES; attempt++ {
+		data, err := fetchFromYTS(ctx, page, limit, query, sortBy, orderBy, false)
+		if err == nil {
+			return data, nil
+		}
+		lastErr = err
+
+		if attempt < SYNC_RETRIES {
+			// Stop backing off once the caller is gone
+			select {
+			case <-time.After(delay + time.Duration(rand.Int63n(int64(delay)))):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			delay *= 2
+		}
+	}
+	return nil, fmt.Errorf("giving up after %d attempts: %w", SYNC_RETRIES, lastErr)
 }
 
 // Sync popular pages to cache
@@ -148,19 +360,19 @@ func syncCache() {
 		for page := 1; page <= 3; page++ { // Cache 3 pages for each sort type
 			cacheKey := getCacheKey(page, 20, "", combo.sortBy, combo.orderBy)
 
-			data, err := fetchFromYTS(page, 20, "", combo.sortBy, combo.orderBy)
+			data, err := fetchWithRetry(context.Background(), page, 20, "", combo.sortBy, combo.orderBy)
+			stats.recordSync(combo.name, err)
 			if err != nil {
-				fmt.Printf("[%s] Error syncing %s page %d: %v\n", time.Now().Format("15:04:05"), combo.name, page, err)
-				continue
-			}
+				// Keep whatever was cached before rather than leaving a hole
+				fmt.Printf("[%s] Error syncing %s page %d, keeping previous data: %v\n", time.Now().Format("15:04:05"), combo.name, page, err)
+			} else {
+				storeInCache(cacheKey, data)
 
-			cache.Lock()
-			cache.data[cacheKey] = data
-			cache.Unlock()
+				totalCached++
+			}
 
-			totalCached++
-			// Small delay to avoid rate limiting
-			time.Sleep(500 * time.Millisecond)
+			// Randomized delay to avoid rate limiting and look less bot-like
+			time.Sleep(SYNC_DELAY_MIN + time.Duration(rand.Int63n(int64(SYNC_DELAY_JITTER))))
 		}
 	}
 
@@ -186,6 +398,84 @@ func startPeriodicSync() {
 	}()
 }
 
+// Fetch from YTS and store the result in the cache. Concurrent misses for the
+// same key share a single upstream request, while each caller still stops
+// waiting as soon as its own request is cancelled.
+func fetchAndCache(ctx context.Context, cacheKey string, fetch func(context.Context) (map[string]interface{}, error)) (*CacheEntry, error) {
+	ch := fetchGroup.DoChan(cacheKey, func() (interface{}, error) {
+		// Detach from the first caller so its disconnect doesn't fail the others;
+		// ytsClient's timeout still bounds the request
+		data, err := fetch(context.WithoutCancel(ctx))
+		if err != nil {
+			return nil, err
+		}
+
+		return storeInCache(cacheKey, data), nil
+	})
+
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(*CacheEntry), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Look up a cache key, refreshing it from YTS when missing or expired.
+// Falls back to the stale copy when the refresh fails.
+// Returns the cache entry and the cache status: HIT, MISS or STALE.
+func getCachedOrFetch(ctx context.Context, cacheKey string, fetch func(context.Context) (map[string]interface{}, error)) (*CacheEntry, string, error) {
+	cache.RLock()
+	cached, exists := cache.data[cacheKey]
+	cache.RUnlock()
+
+	if exists && time.Since(cached.fetchedAt) < CACHE_TTL {
+		stats.cacheHits.Add(1)
+		return cached, "HIT", nil
+	}
+
+	stats.cacheMisses.Add(1)
+	entry, err := fetchAndCache(ctx, cacheKey, fetch)
+	if err != nil && exists {
+		// YTS is down - serve the last good copy rather than failing
+		fmt.Printf("[%s] ! Fetch failed, serving stale cache for %s: %v\n", time.Now().Format("15:04:05"), cacheKey, err)
+		stats.staleServed.Add(1)
+		return cached, "STALE", nil
+	} else if err != nil {
+		return nil, "", err
+	}
+
+	return entry, "MISS", nil
+}
+
+// Set Last-Modified and Cache-Control for a cache entry, answering 304 when
+// the client's copy is still current. Returns true if the 304 was sent.
+func writeCacheHeaders(w http.ResponseWriter, r *http.Request, entry *CacheEntry) bool {
+	// HTTP dates only have second precision
+	lastModified := entry.fetchedAt.UTC().Truncate(time.Second)
+	w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(CLIENT_MAX_AGE.Seconds())))
+
+	if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !lastModified.After(since) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// Parse an optional boolean query parameter; ok is false if it is malformed
+func parseBoolParam(r *http.Request, name string) (value bool, ok bool) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return false, true
+	}
+	value, err := strconv.ParseBool(raw)
+	return value, err == nil
+}
+
 // API handler matching YTS.mx format
 func handleListMovies(w http.ResponseWriter, r *http.Request) {
 	// Parse query parameters
@@ -213,43 +503,79 @@ func handleListMovies(w http.ResponseWriter, r *http.Request) {
 		orderBy = "desc"
 	}
 
-	cacheKey := getCacheKey(page, limit, query, sortBy, orderBy)
+	withRTRatings, ok := parseBoolParam(r, "with_rt_ratings")
+	if !ok {
+		http.Error(w, `{"error": "Invalid with_rt_ratings"}`, http.StatusBadRequest)
+		return
+	}
 
-	// Try to get from cache first
-	cache.RLock()
-	cachedData, exists := cache.data[cacheKey]
-	cache.RUnlock()
+	cacheKey := getCacheKey(page, limit, query, sortBy, orderBy)
+	if withRTRatings {
+		cacheKey += "_rt"
+	}
 
-	var result map[string]interface{}
+	// Serve from cache, fetching fresh data on a miss
+	entry, cacheStatus, err := getCachedOrFetch(r.Context(), cacheKey, func(ctx context.Context) (map[string]interface{}, error) {
+		return fetchFromYTS(ctx, page, limit, query, sortBy, orderBy, withRTRatings)
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
 
-	if exists {
-		// Return cached data
-		result = cachedData.(map[string]interface{})
+	if cacheStatus == "HIT" {
 		fmt.Printf("[%s] ✓ Cache hit: page=%d sort=%s order=%s\n",
 			time.Now().Format("15:04:05"), page, sortBy, orderBy)
 	} else {
-		// Fetch fresh data and cache it
-		fmt.Printf("[%s] ✗ Cache miss, fetching: page=%d sort=%s order=%s query=%s\n",
+		fmt.Printf("[%s] ✗ Cache miss, fetched: page=%d sort=%s order=%s query=%s\n",
 			time.Now().Format("15:04:05"), page, sortBy, orderBy, query)
+	}
 
-		data, err := fetchFromYTS(page, limit, query, sortBy, orderBy)
-		if err != nil {
-			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
-			return
-		}
+	// Return JSON response
+	w.Header().Set("X-Cache", cacheStatus)
+	setCORSHeaders(w, r)
+	if writeCacheHeaders(w, r, entry) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entry.data)
+}
+
+// Movie details handler matching YTS.mx format
+func handleMovieDetails(w http.ResponseWriter, r *http.Request) {
+	movieID, err := strconv.Atoi(r.URL.Query().Get("movie_id"))
+	if err != nil || movieID <= 0 {
+		http.Error(w, `{"error": "Invalid movie_id"}`, http.StatusBadRequest)
+		return
+	}
 
-		// Cache the result
-		cache.Lock()
-		cache.data[cacheKey] = data
-		cache.Unlock()
+	withImages := r.URL.Query().Get("with_images") == "true"
+	withCast := r.URL.Query().Get("with_cast") == "true"
 
-		result = data
+	withRTRatings, ok := parseBoolParam(r, "with_rt_ratings")
+	if !ok {
+		http.Error(w, `{"error": "Invalid with_rt_ratings"}`, http.StatusBadRequest)
+		return
 	}
 
-	// Return JSON response
+	cacheKey := fmt.Sprintf("details_%d_images_%t_cast_%t_rt_%t", movieID, withImages, withCast, withRTRatings)
+	entry, cacheStatus, err := getCachedOrFetch(r.Context(), cacheKey, func(ctx context.Context) (map[string]interface{}, error) {
+		return fetchMovieDetails(ctx, movieID, withImages, withCast, withRTRatings)
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Printf("[%s] Movie details %d: %s\n", time.Now().Format("15:04:05"), movieID, cacheStatus)
+
+	w.Header().Set("X-Cache", cacheStatus)
+	setCORSHeaders(w, r)
+	if writeCacheHeaders(w, r, entry) {
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	json.NewEncoder(w).Encode(result)
+	json.NewEncoder(w).Encode(entry.data)
 }
 
 // Health check endpoint
@@ -266,17 +592,132 @@ func handleHealth(w http.ResponseWriter, r *http.Request) {
 		"syncInterval": SYNC_INTERVAL.String(),
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	setCORSHeaders(w, r)
+	json.NewEncoder(w).Encode(response)
+}
+
+// Sort and order values accepted by the YTS API
+var (
+	validSortBy  = map[string]bool{"title": true, "year": true, "rating": true, "peers": true, "seeds": true, "download_count": true, "like_count": true, "date_added": true}
+	validOrderBy = map[string]bool{"asc": true, "desc": true}
+)
+
+// Pre-cache the first N pages of an arbitrary sort combination
+func handleWarmup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !hasSyncToken(r) {
+		http.Error(w, `{"error": "Missing or invalid sync token"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		SortBy  string `json:"sortBy"`
+		OrderBy string `json:"orderBy"`
+		Pages   int    `json:"pages"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	if request.OrderBy == "" {
+		request.OrderBy = "desc"
+	}
+	if !validSortBy[request.SortBy] || !validOrderBy[request.OrderBy] {
+		http.Error(w, `{"error": "Invalid sortBy or orderBy"}`, http.StatusBadRequest)
+		return
+	}
+	if request.Pages < 1 || request.Pages > MAX_PAGES {
+		http.Error(w, fmt.Sprintf(`{"error": "pages must be between 1 and %d"}`, MAX_PAGES), http.StatusBadRequest)
+		return
+	}
+
+	cached, failed := 0, 0
+	for page := 1; page <= request.Pages; page++ {
+		if page > 1 {
+			// Stop pacing requests for a client that has gone away
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(SYNC_DELAY_MIN + time.Duration(rand.Int63n(int64(SYNC_DELAY_JITTER)))):
+			}
+		}
+
+		data, err := fetchWithRetry(r.Context(), page, 20, "", request.SortBy, request.OrderBy)
+		if err != nil {
+			fmt.Printf("[%s] Warm-up failed for %s page %d: %v\n", time.Now().Format("15:04:05"), request.SortBy, page, err)
+			failed++
+			continue
+		}
+
+		storeInCache(getCacheKey(page, 20, "", request.SortBy, request.OrderBy), data)
+		cached++
+	}
+
+	response := map[string]interface{}{
+		"cached": cached,
+		"failed": failed,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Cache effectiveness and sync statistics
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	hits := stats.cacheHits.Load()
+	misses := stats.cacheMisses.Load()
+
+	hitRate := 0.0
+	if hits+misses > 0 {
+		hitRate = float64(hits) / float64(hits+misses)
+	}
+
+	syncBySort := map[string]interface{}{}
+	stats.syncBySort.Range(func(key, value interface{}) bool {
+		counters := value.(*SyncCounters)
+		syncBySort[key.(string)] = map[string]int64{
+			"successes": counters.Successes.Load(),
+			"failures":  counters.Failures.Load(),
+		}
+		return true
+	})
+
+	cache.RLock()
+	cacheSize := len(cache.data)
+	cache.RUnlock()
+
+	response := map[string]interface{}{
+		"cacheHits":   hits,
+		"cacheMisses": misses,
+		"staleServed": stats.staleServed.Load(),
+		"hitRate":     hitRate,
+		"cacheSize":   cacheSize,
+		"syncBySort":  syncBySort,
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
 func main() {
+	loadAllowedOrigins()
+	loadMagnetTrackers()
+	loadSyncToken()
+
 	// Start periodic sync in background
 	startPeriodicSync()
 
 	// Setup HTTP routes
 	http.HandleFunc("/api/v2/list_movies.json", handleListMovies)
+	http.HandleFunc("/api/v2/movie_details.json", handleMovieDetails)
 	http.HandleFunc("/health", handleHealth)
+	http.HandleFunc("/stats", handleStats)
+	http.HandleFunc("/warmup", handleWarmup)
 
 	port := DEFAULT_PORT
 	addr := fmt.Sprintf("0.0.0.0:%d", port)
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Sends every request to a test server instead of YTS
+type rewriteTransport struct {
+	target *url.URL
+}
+
+func (t rewriteTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme = t.target.Scheme
+	r.URL.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// Point ytsClient at a test server running handler, with an empty cache
+// and fresh stats, for the rest of the test
+func withYTS(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	target, _ := url.Parse(server.URL)
+
+	savedClient := ytsClient
+	ytsClient = &http.Client{Timeout: savedClient.Timeout, Transport: rewriteTransport{target}}
+	savedStats := stats
+	stats = &Stats{}
+	resetCache()
+	t.Cleanup(func() {
+		server.Close()
+		ytsClient = savedClient
+		stats = savedStats
+		resetCache()
+	})
+}
+
+func resetCache() {
+	cache.Lock()
+	cache.data = make(map[string]*CacheEntry)
+	cache.Unlock()
+}
+
+// A list_movies response with one movie and one torrent
+func sampleListResponse(title string) map[string]interface{} {
+	return map[string]interface{}{
+		"status": "ok",
+		"data": map[string]interface{}{
+			"movies": []interface{}{
+				map[string]interface{}{
+					"title": title,
+					"torrents": []interface{}{
+						map[string]interface{}{"hash": "0123456789ABCDEF0123456789ABCDEF01234567", "quality": "1080p"},
+					},
+				},
+			},
+		},
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func TestFetchWithRetryRecoversFromFlakyUpstream(t *testing.T) {
+	var requests atomic.Int32
+	var down atomic.Bool
+	withYTS(t, func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 || down.Load() {
+			http.Error(w, "try again", http.StatusBadGateway)
+			return
+		}
+		writeJSON(w, sampleListResponse("Flaky"))
+	})
+
+	data, err := fetchWithRetry(t.Context(), 1, 20, "", "date_added", "desc")
+	if err != nil {
+		t.Fatalf("fetchWithRetry: %v", err)
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("upstream requests = %d, want 2", n)
+	}
+	if data["status"] != "ok" {
+		t.Errorf("data = %v, want the second response", data)
+	}
+
+	// A caller that goes away stops the backoff instead of sitting it out
+	down.Store(true)
+	ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := fetchWithRetry(ctx, 1, 20, "", "date_added", "desc"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("cancelled fetchWithRetry: err = %v, want the context's error", err)
+	}
+	if elapsed := time.Since(start); elapsed >= RETRY_BASE_DELAY {
+		t.Errorf("cancelled fetchWithRetry took %v, want it to stop before the first backoff ends", elapsed)
+	}
+}
+
+func TestListMoviesServesStaleCacheDuringOutage(t *testing.T) {
+	withYTS(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	})
+
+	// Nothing cached yet, so the outage is an error
+	w := httptest.NewRecorder()
+	handleListMovies(w, httptest.NewRequest(http.MethodGet, "/api/v2/list_movies.json?page=1", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("empty cache: status = %d, want 500", w.Code)
+	}
+
+	cacheKey := getCacheKey(1, 20, "", "date_added", "desc")
+	cache.Lock()
+	cache.data[cacheKey] = &CacheEntry{data: sampleListResponse("Cached"), fetchedAt: time.Now().Add(-2 * CACHE_TTL)}
+	cache.Unlock()
+
+	w = httptest.NewRecorder()
+	handleListMovies(w, httptest.NewRequest(http.MethodGet, "/api/v2/list_movies.json?page=1", nil))
+	if w.Code != http.StatusOK || w.Header().Get("X-Cache") != "STALE" {
+		t.Fatalf("status = %d, X-Cache = %q, want 200 STALE", w.Code, w.Header().Get("X-Cache"))
+	}
+	if !strings.Contains(w.Body.String(), "Cached") {
+		t.Errorf("body = %s, want the cached movie", w.Body)
+	}
+	if n := stats.staleServed.Load(); n != 1 {
+		t.Errorf("staleServed = %d, want 1", n)
+	}
+}
+
+func TestFetchFromYTSGivesUpOnHungUpstream(t *testing.T) {
+	if ytsClient.Timeout <= 0 {
+		t.Fatal("ytsClient has no timeout")
+	}
+	withYTS(t, func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done() // Never answer
+	})
+
+	ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := fetchFromYTS(ctx, 1, 20, "", "", "", false); err == nil {
+		t.Fatal("fetch from a hung upstream succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("fetch took %s after its context ended", elapsed)
+	}
+}
+
+func TestConcurrentMissesShareOneFetch(t *testing.T) {
+	var requests atomic.Int32
+	withYTS(t, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		time.Sleep(200 * time.Millisecond) // Keep the fetch in flight while the others arrive
+		writeJSON(w, sampleListResponse("Popular"))
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			handleListMovies(w, httptest.NewRequest(http.MethodGet, "/api/v2/list_movies.json?page=7", nil))
+			if w.Code != http.StatusOK {
+				t.Errorf("status = %d, want 200", w.Code)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := requests.Load(); n != 1 {
+		t.Errorf("upstream requests = %d, want 1", n)
+	}
+}
+
+func TestStatsCountHitsMissesAndSyncs(t *testing.T) {
+	withYTS(t, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, sampleListResponse("Counted"))
+	})
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		handleListMovies(w, httptest.NewRequest(http.MethodGet, "/api/v2/list_movies.json?page=3", nil))
+	}
+	stats.recordSync("Latest", nil)
+	stats.recordSync("Latest", errors.New("timeout"))
+
+	w := httptest.NewRecorder()
+	handleStats(w, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	var response struct {
+		CacheHits   int64                       `json:"cacheHits"`
+		CacheMisses int64                       `json:"cacheMisses"`
+		HitRate     float64                     `json:"hitRate"`
+		SyncBySort  map[string]map[string]int64 `json:"syncBySort"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.CacheHits != 1 || response.CacheMisses != 1 || response.HitRate != 0.5 {
+		t.Errorf("hits = %d, misses = %d, hit rate = %v, want 1, 1, 0.5", response.CacheHits, response.CacheMisses, response.HitRate)
+	}
+	if latest := response.SyncBySort["Latest"]; latest["successes"] != 1 || latest["failures"] != 1 {
+		t.Errorf("Latest sync counters = %v, want one success and one failure", latest)
+	}
+}
+
+func TestCORSAllowlist(t *testing.T) {
+	healthFrom := func(origin string) string {
+		r := httptest.NewRequest(http.MethodGet, "/health", nil)
+		r.Header.Set("Origin", origin)
+		w := httptest.NewRecorder()
+		handleHealth(w, r)
+		return w.Header().Get("Access-Control-Allow-Origin")
+	}
+
+	saved := allowedOrigins
+	t.Cleanup(func() { allowedOrigins = saved })
+
+	allowedOrigins = nil
+	if got := healthFrom("https://anywhere.example.com"); got != "*" {
+		t.Errorf("without an allowlist: Access-Control-Allow-Origin = %q, want *", got)
+	}
+
+	t.Setenv("CORS_ORIGINS", "https://bitplay.example.com, http://localhost:3347")
+	loadAllowedOrigins()
+	if got := healthFrom("http://localhost:3347"); got != "http://localhost:3347" {
+		t.Errorf("allowed origin: Access-Control-Allow-Origin = %q, want it echoed", got)
+	}
+	if got := healthFrom("https://evil.example.com"); got != "" {
+		t.Errorf("disallowed origin: Access-Control-Allow-Origin = %q, want none", got)
+	}
+}
+
+func TestWarmupCachesRequestedPages(t *testing.T) {
+	withYTS(t, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, sampleListResponse("Warm"))
+	})
+	saved := syncToken
+	syncToken = "secret"
+	t.Cleanup(func() { syncToken = saved })
+
+	warmup := func(token, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/warmup", strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handleWarmup(w, r)
+		return w
+	}
+
+	if w := warmup("", `{"sortBy": "year", "pages": 2}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("without a token: status = %d, want 401", w.Code)
+	}
+	if w := warmup("wrong", `{"sortBy": "year", "pages": 2}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token: status = %d, want 401", w.Code)
+	}
+	if w := warmup("secret", `{"sortBy": "bogus", "pages": 2}`); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid sortBy: status = %d, want 400", w.Code)
+	}
+
+	w := warmup("secret", `{"sortBy": "year", "pages": 2}`)
+	var result struct {
+		Cached int `json:"cached"`
+		Failed int `json:"failed"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("decoding %q: %v", w.Body, err)
+	}
+	if result.Cached != 2 || result.Failed != 0 {
+		t.Errorf("cached = %d, failed = %d, want 2 and 0", result.Cached, result.Failed)
+	}
+
+	for _, page := range []string{"1", "2"} {
+		w := httptest.NewRecorder()
+		handleListMovies(w, httptest.NewRequest(http.MethodGet, "/api/v2/list_movies.json?sort_by=year&page="+page, nil))
+		if got := w.Header().Get("X-Cache"); got != "HIT" {
+			t.Errorf("page %s after warm-up: X-Cache = %q, want HIT", page, got)
+		}
+	}
+}
+
+func TestMovieDetailsAreCachedWithMagnets(t *testing.T) {
+	var requests atomic.Int32
+	withYTS(t, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.URL.Query().Get("movie_id") != "42" {
+			t.Errorf("upstream movie_id = %q, want 42", r.URL.Query().Get("movie_id"))
+		}
+		movie := sampleListResponse("Details")["data"].(map[string]interface{})["movies"].([]interface{})[0]
+		writeJSON(w, map[string]interface{}{"status": "ok", "data": map[string]interface{}{"movie": movie}})
+	})
+
+	var response struct {
+		Data struct {
+			Movie struct {
+				Torrents []struct {
+					MagnetURL string `json:"magnetUrl"`
+				} `json:"torrents"`
+			} `json:"movie"`
+		} `json:"data"`
+	}
+	for _, want := range []string{"MISS", "HIT"} {
+		w := httptest.NewRecorder()
+		handleMovieDetails(w, httptest.NewRequest(http.MethodGet, "/api/v2/movie_details.json?movie_id=42", nil))
+		if got := w.Header().Get("X-Cache"); got != want {
+			t.Errorf("X-Cache = %q, want %s", got, want)
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("decoding %q: %v", w.Body, err)
+		}
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("upstream requests = %d, want 1", n)
+	}
+	if torrents := response.Data.Movie.Torrents; len(torrents) != 1 || !strings.HasPrefix(torrents[0].MagnetURL, "magnet:?xt=urn:btih:") {
+		t.Errorf("torrents = %+v, want one with a magnet", torrents)
+	}
+
+	w := httptest.NewRecorder()
+	handleMovieDetails(w, httptest.NewRequest(http.MethodGet, "/api/v2/movie_details.json?movie_id=abc", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid movie_id: status = %d, want 400", w.Code)
+	}
+}
+
+func TestMagnetTrackersOverride(t *testing.T) {
+	saved := magnetTrackers
+	t.Cleanup(func() { magnetTrackers = saved })
+
+	t.Setenv("MAGNET_TRACKERS", "udp://tracker.example.com:1337/announce, http://other.example.com/announce")
+	loadMagnetTrackers()
+	magnet := buildMagnetLink("0123456789ABCDEF0123456789ABCDEF01234567", "Some Movie", "720p")
+
+	parsed, err := url.ParseQuery(strings.TrimPrefix(magnet, "magnet:?"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"udp://tracker.example.com:1337/announce", "http://other.example.com/announce"}
+	if got := parsed["tr"]; strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("trackers = %v, want %v", got, want)
+	}
+	if got := parsed.Get("dn"); got != "Some Movie 720p" {
+		t.Errorf("dn = %q, want %q", got, "Some Movie 720p")
+	}
+}
+
+func TestMagnetNamesEncodeSpacesAsPercent20(t *testing.T) {
+	magnet := buildMagnetLink("0123456789ABCDEF0123456789ABCDEF01234567", "Amélie & Co: 100%", "1080p")
+
+	dn := strings.SplitN(strings.SplitN(magnet, "&dn=", 2)[1], "&", 2)[0]
+	if want := "Am%C3%A9lie%20%26%20Co%3A%20100%25%201080p"; dn != want {
+		t.Errorf("dn = %q, want %q", dn, want)
+	}
+	if decoded, err := url.PathUnescape(dn); err != nil || decoded != "Amélie & Co: 100% 1080p" {
+		t.Errorf("path-decoded dn = %q (%v)", decoded, err)
+	}
+}
+
+func TestListMoviesAnswersConditionalRequests(t *testing.T) {
+	withYTS(t, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, sampleListResponse("Conditional"))
+	})
+	const target = "/api/v2/list_movies.json?page=2"
+
+	w := httptest.NewRecorder()
+	handleListMovies(w, httptest.NewRequest(http.MethodGet, target, nil))
+	lastModified := w.Header().Get("Last-Modified")
+	if w.Code != http.StatusOK || lastModified == "" {
+		t.Fatalf("first request: status %d, Last-Modified %q", w.Code, lastModified)
+	}
+	if got, want := w.Header().Get("Cache-Control"), fmt.Sprintf("public, max-age=%d", int(CLIENT_MAX_AGE.Seconds())); got != want {
+		t.Errorf("Cache-Control = %q, want %q", got, want)
+	}
+
+	// The client's copy is current
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	r.Header.Set("If-Modified-Since", lastModified)
+	w = httptest.NewRecorder()
+	handleListMovies(w, r)
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("conditional request: status %d with %d body bytes, want an empty 304", w.Code, w.Body.Len())
+	}
+
+	// The client's copy predates the cached entry
+	modified, _ := http.ParseTime(lastModified)
+	r = httptest.NewRequest(http.MethodGet, target, nil)
+	r.Header.Set("If-Modified-Since", modified.Add(-time.Minute).Format(http.TimeFormat))
+	w = httptest.NewRecorder()
+	handleListMovies(w, r)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Conditional") {
+		t.Errorf("stale client copy: status %d, want the full list", w.Code)
+	}
+}
+
+func TestRTRatingsAreForwardedAndCachedSeparately(t *testing.T) {
+	var forwarded []string
+	withYTS(t, func(w http.ResponseWriter, r *http.Request) {
+		forwarded = append(forwarded, r.URL.Path+"?with_rt_ratings="+r.URL.Query().Get("with_rt_ratings"))
+		movie := sampleListResponse("Rated")["data"].(map[string]interface{})["movies"].([]interface{})[0].(map[string]interface{})
+		movie["imdb_code"] = "tt0111161"
+		movie["rt_critics"] = 91
+		if strings.HasSuffix(r.URL.Path, "movie_details.json") {
+			writeJSON(w, map[string]interface{}{"status": "ok", "data": map[string]interface{}{"movie": movie}})
+			return
+		}
+		writeJSON(w, map[string]interface{}{"status": "ok", "data": map[string]interface{}{"movies": []interface{}{movie}}})
+	})
+
+	for _, target := range []string{
+		"/api/v2/list_movies.json?with_rt_ratings=true",
+		"/api/v2/list_movies.json",
+		"/api/v2/movie_details.json?movie_id=42&with_rt_ratings=true",
+	} {
+		w := httptest.NewRecorder()
+		if strings.Contains(target, "details") {
+			handleMovieDetails(w, httptest.NewRequest(http.MethodGet, target, nil))
+		} else {
+			handleListMovies(w, httptest.NewRequest(http.MethodGet, target, nil))
+		}
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want 200", target, w.Code)
+		}
+		if !strings.Contains(w.Body.String(), `"imdb_code":"tt0111161"`) || !strings.Contains(w.Body.String(), `"rt_critics":91`) {
+			t.Errorf("%s: body %s is missing imdb_code or rt_critics", target, w.Body)
+		}
+	}
+
+	want := []string{
+		"/api/v2/list_movies.json?with_rt_ratings=true",
+		"/api/v2/list_movies.json?with_rt_ratings=",
+		"/api/v2/movie_details.json?with_rt_ratings=true",
+	}
+	if fmt.Sprint(forwarded) != fmt.Sprint(want) {
+		t.Errorf("upstream requests = %q, want %q", forwarded, want)
+	}
+
+	for _, target := range []string{
+		"/api/v2/list_movies.json?with_rt_ratings=maybe",
+		"/api/v2/movie_details.json?movie_id=42&with_rt_ratings=maybe",
+	} {
+		w := httptest.NewRecorder()
+		if strings.Contains(target, "details") {
+			handleMovieDetails(w, httptest.NewRequest(http.MethodGet, target, nil))
+		} else {
+			handleListMovies(w, httptest.NewRequest(http.MethodGet, target, nil))
+		}
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", target, w.Code)
+		}
+	}
+}